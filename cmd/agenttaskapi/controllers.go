@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/astockwell/ffn/pkg/service"
 	"github.com/julienschmidt/httprouter"
@@ -26,7 +27,35 @@ func route_Index(dso *DataSourceOrchestration) httprouter.Handle {
 	}
 }
 
-// route_Tasks_New_POST assigns a task to an Agent, if available and permissable
+// route_Priorities lists the configured priority levels, most urgent first
+func route_Priorities(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Priorities(): Started")
+
+		dso.Renderer.JSON(w, http.StatusOK, service.PriorityLevels())
+	}
+}
+
+// route_SLA returns the SLA targets tasks are held to
+func route_SLA(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_SLA(): Started")
+
+		dso.Renderer.JSON(w, http.StatusOK, dso.Store.SLAPolicy())
+	}
+}
+
+// route_Escalations lists the rules stale tasks are escalated by
+func route_Escalations(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Escalations(): Started")
+
+		dso.Renderer.JSON(w, http.StatusOK, dso.Store.EscalationRules())
+	}
+}
+
+// route_Tasks_New_POST assigns a task to an Agent, if available and permissable,
+// otherwise queues it until one is (provided an agent with the required skills exists)
 func route_Tasks_New_POST(dso *DataSourceOrchestration) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
 		log.Tracef("route_Tasks_New_POST(): Started")
@@ -49,15 +78,47 @@ func route_Tasks_New_POST(dso *DataSourceOrchestration) httprouter.Handle {
 			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("New Task is invalid: %v", err)})
 			return
 		}
+		err = dso.Store.ValidateSkills(append(append(service.Skills{}, newTask.ReqSkills...), newTask.PrefSkills...))
+		if err != nil {
+			log.Warnf("route_Tasks_New_POST() --> Store.ValidateSkills(newTask skills): %v; Task: %#v", err, newTask)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("New Task is invalid: %v", err)})
+			return
+		}
 		log.Tracef("route_Tasks_New_POST(): newTask is valid")
 
+		// Optionally override the deployment's assignment strategy for this task
+		opts := service.AssignmentOptions{Actor: r.Header.Get("X-Actor")}
+		if name := r.URL.Query().Get("strategy"); name != "" {
+			opts.Strategy, err = service.LookupAssignmentStrategy(name)
+			if err != nil {
+				log.Warnf("route_Tasks_New_POST() --> service.LookupAssignmentStrategy(%q): %v", name, err)
+				dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v (available: %s)", err, strings.Join(service.AssignmentStrategyNames(), ", "))})
+				return
+			}
+		}
+
 		// Assign task
-		agentAssignedID, taskID, err := dso.Store.AddTaskToAgent(&newTask)
+		agentAssignedID, taskID, err := dso.Store.AddTaskToAgentWithOptions(&newTask, opts)
 		if err != nil {
 			log.Warnf("route_Tasks_New_POST() --> Store.AddTaskToAgent(newTask): %v; Task: %#v", err, newTask)
 			dso.Renderer.JSON(w, http.StatusConflict, map[string]string{"error": fmt.Sprintf("Could not assign task: %v", err)})
 			return
 		}
+
+		// No agent was available; the task was parked in the pending queue
+		if agentAssignedID == 0 {
+			log.Tracef("route_Tasks_New_POST(): newTask (ID: %v) queued pending an available agent", taskID)
+
+			queuedTask, err := dso.Store.FindPendingTask(taskID)
+			if err != nil {
+				log.Errorf("route_Tasks_New_POST() --> Store.FindPendingTask(taskID): %v", err)
+				dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Could not find queued task (%v) in data store: %v", taskID, err)})
+				return
+			}
+
+			dso.Renderer.JSON(w, http.StatusAccepted, queuedTask)
+			return
+		}
 		log.Tracef("route_Tasks_New_POST(): newTask (ID: %v) assigned to agent (ID: %v) successfully", taskID, agentAssignedID)
 
 		// Fetch assigned task details for response
@@ -73,6 +134,58 @@ func route_Tasks_New_POST(dso *DataSourceOrchestration) httprouter.Handle {
 	}
 }
 
+// route_Tasks_DryRun_POST reports how a new task would be assigned, agent by agent,
+// without creating it
+func route_Tasks_DryRun_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_DryRun_POST(): Started")
+
+		// Parse request body JSON
+		var newTask service.Task
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&newTask)
+		if err != nil {
+			log.Warnf("route_Tasks_DryRun_POST() --> json.Decode(&newTask): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		// Validate task, as for a new task
+		err = newTask.IsValid()
+		if err == nil {
+			err = dso.Store.ValidateSkills(newTask.ReqSkills)
+		}
+		if err == nil {
+			err = dso.Store.ValidateSkills(newTask.PrefSkills)
+		}
+		if err != nil {
+			log.Warnf("route_Tasks_DryRun_POST() --> !newTask.IsValid(): %v; Task: %#v", err, newTask)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("New Task is invalid: %v", err)})
+			return
+		}
+
+		// Optionally override the deployment's assignment strategy, as for a new task
+		var opts service.AssignmentOptions
+		if name := r.URL.Query().Get("strategy"); name != "" {
+			opts.Strategy, err = service.LookupAssignmentStrategy(name)
+			if err != nil {
+				log.Warnf("route_Tasks_DryRun_POST() --> service.LookupAssignmentStrategy(%q): %v", name, err)
+				dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v (available: %s)", err, strings.Join(service.AssignmentStrategyNames(), ", "))})
+				return
+			}
+		}
+
+		run, err := dso.Store.DryRunAssignment(&newTask, opts)
+		if err != nil {
+			log.Errorf("route_Tasks_DryRun_POST() --> Store.DryRunAssignment(newTask): %v; Task: %#v", err, newTask)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Could not run assignment: %v", err)})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, run)
+	}
+}
+
 // route_Tasks_Update_Complete_POST marks the task as complete via the given task ID
 func route_Tasks_Update_Complete_POST(dso *DataSourceOrchestration) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
@@ -89,9 +202,9 @@ func route_Tasks_Update_Complete_POST(dso *DataSourceOrchestration) httprouter.H
 		}
 		log.Tracef("route_Tasks_Update_Complete_POST(): Decoded JSON to task: %v", task)
 
-		err = dso.Store.MarkAsCompleted(task.ID)
+		err = dso.Store.CompleteTaskWithOptions(task.ID, service.CompletionOptions{Resolution: task.Resolution, Outcome: task.Outcome})
 		if err != nil {
-			log.Warnf("route_Tasks_New_POST() --> Store.MarkAsCompleted(task.ID): %v", err)
+			log.Warnf("route_Tasks_New_POST() --> Store.CompleteTaskWithOptions(task.ID): %v", err)
 			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error occurred marking task as completed: %v", err)})
 			return
 		}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// agentErrorStatus maps agent management errors to HTTP status codes
+func agentErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case service.ErrAgentNotFound:
+		return http.StatusNotFound
+	case service.ErrAgentHasTasks, service.ErrAgentSkillInUse:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// agentIDParam parses the :id URL parameter
+func agentIDParam(rp httprouter.Params) (uint, error) {
+	id, err := strconv.ParseUint(rp.ByName("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid agent ID: %q", rp.ByName("id"))
+	}
+	return uint(id), nil
+}
+
+// route_Agent returns a single agent, with the tasks currently assigned to them
+func route_Agent(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Tasks lists the tasks currently assigned to an agent, in queue order
+func route_Agent_Tasks(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Tasks(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+
+		tasks := agent.Tasks
+		if tasks == nil {
+			tasks = []*service.Task{}
+		}
+		dso.Renderer.JSON(w, http.StatusOK, tasks)
+	}
+}
+
+// route_Agents_New_POST onboards a new agent
+func route_Agents_New_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agents_New_POST(): Started")
+
+		// Parse request body JSON
+		var agent service.Agent
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&agent)
+		if err != nil {
+			log.Warnf("route_Agents_New_POST() --> json.Decode(&agent): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		// New agents start out active with an empty queue
+		agent.Deactivated = false
+		agent.Tasks = []*service.Task{}
+
+		err = dso.Store.AddAgents([]*service.Agent{&agent})
+		if err != nil {
+			log.Warnf("route_Agents_New_POST() --> Store.AddAgents(agent): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("New Agent is invalid: %v", errors.Cause(err))})
+			return
+		}
+
+		// The new agent may have picked up waiting tasks
+		created, err := dso.Store.FindAgent(agent.ID)
+		if err != nil {
+			log.Errorf("route_Agents_New_POST() --> Store.FindAgent(agent.ID): %v", err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusCreated, created)
+	}
+}
+
+// route_Agent_Skills_PUT replaces an agent's skills and proficiency levels
+func route_Agent_Skills_PUT(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Skills_PUT(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse request body JSON
+		var body struct {
+			Skills      service.Skills      `json:"skills"`
+			SkillLevels service.SkillLevels `json:"skill_levels"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&body)
+		if err != nil {
+			log.Warnf("route_Agent_Skills_PUT() --> json.Decode(&body): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		err = dso.Store.UpdateAgentSkills(agentID, body.Skills, body.SkillLevels)
+		if err != nil {
+			log.Warnf("route_Agent_Skills_PUT() --> Store.UpdateAgentSkills(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not update agent skills: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Skills_PUT() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Capacity_PUT replaces an agent's capacity limits
+func route_Agent_Capacity_PUT(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Capacity_PUT(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse request body JSON
+		var capacity service.Capacity
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&capacity)
+		if err != nil {
+			log.Warnf("route_Agent_Capacity_PUT() --> json.Decode(&capacity): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		err = dso.Store.SetAgentCapacity(agentID, capacity)
+		if err != nil {
+			log.Warnf("route_Agent_Capacity_PUT() --> Store.SetAgentCapacity(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not update agent capacity: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Capacity_PUT() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Schedule_PUT replaces an agent's shift calendar; a null body removes
+// it, leaving the agent always on shift
+func route_Agent_Schedule_PUT(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Schedule_PUT(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse request body JSON
+		var schedule *service.Schedule
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&schedule)
+		if err != nil {
+			log.Warnf("route_Agent_Schedule_PUT() --> json.Decode(&schedule): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		err = dso.Store.SetAgentSchedule(agentID, schedule)
+		if err != nil {
+			log.Warnf("route_Agent_Schedule_PUT() --> Store.SetAgentSchedule(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not update agent schedule: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Schedule_PUT() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Presence_PUT marks an agent online, away or offline, e.g. {"presence":"away"}
+func route_Agent_Presence_PUT(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Presence_PUT(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse request body JSON
+		var body struct {
+			Presence *service.Presence `json:"presence"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&body)
+		if err != nil {
+			log.Warnf("route_Agent_Presence_PUT() --> json.Decode(&body): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+		if body.Presence == nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": "Missing presence (expected online, away or offline)"})
+			return
+		}
+
+		err = dso.Store.SetAgentPresence(agentID, *body.Presence, r.Header.Get("X-Actor"))
+		if err != nil {
+			log.Warnf("route_Agent_Presence_PUT() --> Store.SetAgentPresence(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not update agent presence: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Presence_PUT() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Presence returns an agent's presence log, oldest change first
+func route_Agent_Presence(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Presence(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		changes, err := dso.Store.PresenceLog(agentID)
+		if err != nil {
+			log.Warnf("route_Agent_Presence() --> Store.PresenceLog(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not retrieve agent presence log: %v", err)})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, changes)
+	}
+}
+
+// route_Agent_Deactivate_POST stops an agent from receiving tasks. The in_flight
+// query parameter decides the fate of tasks they hold: "block" (default) refuses
+// while they hold any, "return" hands them back to the pending queue.
+func route_Agent_Deactivate_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Deactivate_POST(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		policy, err := service.ParseInFlightPolicy(r.URL.Query().Get("in_flight"))
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		err = dso.Store.DeactivateAgent(agentID, policy)
+		if err != nil {
+			log.Warnf("route_Agent_Deactivate_POST() --> Store.DeactivateAgent(%d, %s): %v", agentID, policy, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not deactivate agent: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Deactivate_POST() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_Activate_POST returns a deactivated agent to service
+func route_Agent_Activate_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_Activate_POST(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		err = dso.Store.ActivateAgent(agentID)
+		if err != nil {
+			log.Warnf("route_Agent_Activate_POST() --> Store.ActivateAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not activate agent: %v", err)})
+			return
+		}
+
+		agent, err := dso.Store.FindAgent(agentID)
+		if err != nil {
+			log.Errorf("route_Agent_Activate_POST() --> Store.FindAgent(%d): %v", agentID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving agent from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, agent)
+	}
+}
+
+// route_Agent_DELETE removes an agent, with the same in_flight policy as deactivation
+func route_Agent_DELETE(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Agent_DELETE(): Started")
+
+		agentID, err := agentIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		policy, err := service.ParseInFlightPolicy(r.URL.Query().Get("in_flight"))
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		err = dso.Store.DeleteAgent(agentID, policy)
+		if err != nil {
+			log.Warnf("route_Agent_DELETE() --> Store.DeleteAgent(%d, %s): %v", agentID, policy, err)
+			dso.Renderer.JSON(w, agentErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not delete agent: %v", err)})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, nil)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/unrolled/render"
+)
+
+func Test_route_Agents(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam is working on task 1; Betty and Charlie are idle
+	buildStore := func() *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+				&service.Task{ID: 1, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+			}},
+			&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+		}, nil)
+	}
+	adamWithTask := &service.Agent{ID: 1, Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+		&service.Task{ID: 1, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+	}}
+	betty := &service.Agent{ID: 2, Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}}
+	charlie := &service.Agent{ID: 3, Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}}
+
+	tests := []struct {
+		name                 string // Test name
+		handler              func(*DataSourceOrchestration) httprouter.Handle
+		method               string           // HTTP method
+		agentID              string           // :id URL parameter, if any
+		query                string           // URL query string
+		body                 string           // HTTP request body
+		wantStatus           int              // Expected HTTP response code
+		wantResponseContains []string         // For validating responses
+		wantAgents           []*service.Agent // Expected agents after HTTP request is complete
+	}{
+		{
+			name:                 "Get returns a single agent with their tasks",
+			handler:              route_Agent,
+			method:               "GET",
+			agentID:              "1",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"name":"Adam"`, `"tasks":[{"id":1,`},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Get unknown agent is not found",
+			handler:    route_Agent,
+			method:     "GET",
+			agentID:    "9",
+			wantStatus: http.StatusNotFound,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Tasks lists an agent's queue",
+			handler:              route_Agent_Tasks,
+			method:               "GET",
+			agentID:              "1",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`[{"id":1,`},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Tasks of an idle agent is empty",
+			handler:              route_Agent_Tasks,
+			method:               "GET",
+			agentID:              "2",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`[]`},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Tasks of unknown agent is not found",
+			handler:    route_Agent_Tasks,
+			method:     "GET",
+			agentID:    "9",
+			wantStatus: http.StatusNotFound,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Create onboards an agent",
+			handler:              route_Agents_New_POST,
+			method:               "POST",
+			body:                 `{"name":"Dana","skills":["skill3"]}`,
+			wantStatus:           http.StatusCreated,
+			wantResponseContains: []string{`"id":4`, `"name":"Dana"`},
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie,
+				&service.Agent{ID: 4, Name: "Dana", Skills: service.Skills{service.Skill3}, Tasks: []*service.Task{}},
+			},
+		},
+		{
+			name:                 "Create rejects an unregistered skill",
+			handler:              route_Agents_New_POST,
+			method:               "POST",
+			body:                 `{"name":"Dana","skills":["juggling"]}`,
+			wantStatus:           http.StatusBadRequest,
+			wantResponseContains: []string{"Invalid Skill: juggling"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Create requires a name",
+			handler:              route_Agents_New_POST,
+			method:               "POST",
+			body:                 `{"skills":["skill1"]}`,
+			wantStatus:           http.StatusBadRequest,
+			wantResponseContains: []string{"Agent name is required"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Update skills replaces an agent's skills",
+			handler:    route_Agent_Skills_PUT,
+			method:     "PUT",
+			agentID:    "3",
+			body:       `{"skills":["skill1","skill3"]}`,
+			wantStatus: http.StatusOK,
+			wantAgents: []*service.Agent{adamWithTask, betty,
+				&service.Agent{ID: 3, Name: "Charlie", Skills: service.Skills{service.Skill1, service.Skill3}, Tasks: []*service.Task{}},
+			},
+		},
+		{
+			name:                 "Update skills refuses to drop a skill an in-flight task requires",
+			handler:              route_Agent_Skills_PUT,
+			method:               "PUT",
+			agentID:              "1",
+			body:                 `{"skills":["skill2"]}`,
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"Task 1 requires skill1"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Update skills of unknown agent is not found",
+			handler:    route_Agent_Skills_PUT,
+			method:     "PUT",
+			agentID:    "9",
+			body:       `{"skills":["skill1"]}`,
+			wantStatus: http.StatusNotFound,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Update capacity sets an agent's limits",
+			handler:              route_Agent_Capacity_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"max_tasks":1,"max_tasks_by_priority":{"high":2}}`,
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"max_tasks":1`},
+			wantAgents: []*service.Agent{adamWithTask,
+				&service.Agent{ID: 2, Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3},
+					Capacity: service.Capacity{MaxTasks: 1, MaxTasksByPriority: map[service.Priority]int{service.PriorityHigh: 2}}, Tasks: []*service.Task{}},
+				charlie,
+			},
+		},
+		{
+			name:                 "Update capacity rejects a negative limit",
+			handler:              route_Agent_Capacity_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"max_tasks":-1}`,
+			wantStatus:           http.StatusBadRequest,
+			wantResponseContains: []string{"Invalid max_tasks"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Update capacity rejects an unconfigured priority",
+			handler:    route_Agent_Capacity_PUT,
+			method:     "PUT",
+			agentID:    "2",
+			body:       `{"max_tasks_by_priority":{"critical":2}}`,
+			wantStatus: http.StatusBadRequest,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Update schedule sets an agent's shifts",
+			handler:              route_Agent_Schedule_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"time_zone":"Europe/London","shifts":[{"day":"monday","start":"09:00","end":"17:00"}],"holidays":["2026-12-25"]}`,
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"day":"monday"`},
+			wantAgents: []*service.Agent{adamWithTask,
+				&service.Agent{ID: 2, Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Schedule: &service.Schedule{
+					TimeZone: "Europe/London",
+					Shifts:   []service.Shift{{Day: service.Weekday(time.Monday), Start: "09:00", End: "17:00"}},
+					Holidays: []string{"2026-12-25"},
+				}, Tasks: []*service.Task{}},
+				charlie,
+			},
+		},
+		{
+			name:                 "Update schedule rejects an invalid shift time",
+			handler:              route_Agent_Schedule_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"shifts":[{"day":"monday","start":"9am","end":"17:00"}]}`,
+			wantStatus:           http.StatusBadRequest,
+			wantResponseContains: []string{"Invalid shift time"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Update presence marks an agent away",
+			handler:              route_Agent_Presence_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"presence":"away"}`,
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"presence":"away"`},
+			wantAgents: []*service.Agent{adamWithTask,
+				&service.Agent{ID: 2, Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Presence: service.PresenceAway, Tasks: []*service.Task{}},
+				charlie,
+			},
+		},
+		{
+			name:                 "Update presence rejects an unknown presence",
+			handler:              route_Agent_Presence_PUT,
+			method:               "PUT",
+			agentID:              "2",
+			body:                 `{"presence":"asleep"}`,
+			wantStatus:           http.StatusBadRequest,
+			wantResponseContains: []string{"Invalid Presence"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Update presence of an unknown agent",
+			handler:    route_Agent_Presence_PUT,
+			method:     "PUT",
+			agentID:    "9",
+			body:       `{"presence":"offline"}`,
+			wantStatus: http.StatusNotFound,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Deactivate is blocked by in-flight tasks by default",
+			handler:              route_Agent_Deactivate_POST,
+			method:               "POST",
+			agentID:              "1",
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"in-flight"},
+			wantAgents:           []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:                 "Deactivate with return policy hands in-flight tasks to another agent",
+			handler:              route_Agent_Deactivate_POST,
+			method:               "POST",
+			agentID:              "1",
+			query:                "in_flight=return",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"deactivated":true`},
+			wantAgents: []*service.Agent{
+				&service.Agent{ID: 1, Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Deactivated: true, Tasks: []*service.Task{}},
+				betty,
+				&service.Agent{ID: 3, Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
+				}},
+			},
+		},
+		{
+			name:       "Deactivate rejects an unknown policy",
+			handler:    route_Agent_Deactivate_POST,
+			method:     "POST",
+			agentID:    "2",
+			query:      "in_flight=drop",
+			wantStatus: http.StatusBadRequest,
+			wantAgents: []*service.Agent{adamWithTask, betty, charlie},
+		},
+		{
+			name:       "Delete removes an idle agent",
+			handler:    route_Agent_DELETE,
+			method:     "DELETE",
+			agentID:    "2",
+			wantStatus: http.StatusOK,
+			wantAgents: []*service.Agent{adamWithTask, charlie},
+		},
+		{
+			name:       "Delete with return policy hands in-flight tasks to another agent",
+			handler:    route_Agent_DELETE,
+			method:     "DELETE",
+			agentID:    "1",
+			query:      "in_flight=return",
+			wantStatus: http.StatusOK,
+			wantAgents: []*service.Agent{betty,
+				&service.Agent{ID: 3, Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			store := buildStore()
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    store,
+			}
+
+			// Build test request
+			path := "/agents"
+			params := httprouter.Params{}
+			if tt.agentID != "" {
+				path += "/" + tt.agentID
+				params = append(params, httprouter.Param{Key: "id", Value: tt.agentID})
+			}
+			r, err := http.NewRequest(tt.method, path+"?"+tt.query, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			tt.handler(dso)(w, r, params)
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			for _, want := range tt.wantResponseContains {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			store.TESTING_resetTimestamps()
+			gotAgents, _ := store.ListAgents()
+			assert.Equal(t, tt.wantAgents, gotAgents)
+		})
+	}
+}
+
+func Test_route_Agents_Deactivated_Not_Assigned(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+	}, nil)
+	dso := &DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	}
+	err := store.DeactivateAgent(1, service.InFlightBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func() *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(`{"priority":"high","required_skills":["skill1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+		return w
+	}
+
+	// No active agent has the skill
+	w := post()
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Reactivation brings the agent back into the pool
+	r, err := http.NewRequest("POST", "/agents/1/activate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	route_Agent_Activate_POST(dso)(w, r, httprouter.Params{httprouter.Param{Key: "id", Value: "1"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"Adam"`)
+}
+
+func Test_route_Agents_Presence(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+	}, nil)
+	dso := &DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	}
+
+	post := func() *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(`{"priority":"high","required_skills":["skill1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+		return w
+	}
+	setPresence := func(agentID, presence string) {
+		r, err := http.NewRequest("PUT", "/agents/"+agentID+"/presence", strings.NewReader(`{"presence":"`+presence+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Actor", "supervisor")
+		w := httptest.NewRecorder()
+		route_Agent_Presence_PUT(dso)(w, r, httprouter.Params{httprouter.Param{Key: "id", Value: agentID}})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Adam is at lunch, so Charlie takes the task
+	setPresence("1", "away")
+	w := post()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"Charlie"`)
+
+	// With Charlie logged out too, the next task waits rather than being rejected
+	setPresence("2", "offline")
+	w = post()
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Adam's return picks up the waiting task
+	setPresence("1", "online")
+	pending, _ := store.ListPendingTasks()
+	assert.Empty(t, pending)
+	adam, err := store.FindAgent(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, adam.Tasks, 1)
+
+	// Every change is logged against the agent
+	r, err := http.NewRequest("GET", "/agents/1/presence", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	route_Agent_Presence(dso)(w, r, httprouter.Params{httprouter.Param{Key: "id", Value: "1"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var changes []service.PresenceChange
+	err = json.Unmarshal(w.Body.Bytes(), &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, service.PresenceOnline, changes[0].From)
+		assert.Equal(t, service.PresenceAway, changes[0].To)
+		assert.Equal(t, service.PresenceOnline, changes[1].To)
+		assert.Equal(t, "supervisor", changes[1].Actor)
+		assert.False(t, changes[1].Time.IsZero())
+	}
+}
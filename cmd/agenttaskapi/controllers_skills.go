@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// skillErrorStatus maps skill registry errors to HTTP status codes
+func skillErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case service.ErrSkillNotFound:
+		return http.StatusNotFound
+	case service.ErrSkillExists, service.ErrSkillInUse:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// route_Skills lists all registered skills
+func route_Skills(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Skills(): Started")
+
+		skills, err := dso.Store.ListSkills()
+		if err != nil {
+			log.Errorf("route_Skills() --> Retrieving Skills from Store: %v", err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving skills list from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, skills)
+	}
+}
+
+// route_Skills_New_POST registers a new skill
+func route_Skills_New_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Skills_New_POST(): Started")
+
+		// Parse request body JSON
+		var skill service.SkillDefinition
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&skill)
+		if err != nil {
+			log.Warnf("route_Skills_New_POST() --> json.Decode(&skill): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		err = dso.Store.AddSkill(skill)
+		if err != nil {
+			log.Warnf("route_Skills_New_POST() --> Store.AddSkill(skill): %v", err)
+			dso.Renderer.JSON(w, skillErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not add skill: %v", errors.Cause(err))})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusCreated, skill)
+	}
+}
+
+// route_Skill fetches a single skill by name
+func route_Skill(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Skill(): Started")
+
+		skill, err := dso.Store.FindSkill(service.Skill(rp.ByName("name")))
+		if err != nil {
+			dso.Renderer.JSON(w, skillErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, skill)
+	}
+}
+
+// route_Skill_PUT updates a skill's description; the name is taken from the URL
+func route_Skill_PUT(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Skill_PUT(): Started")
+
+		// Parse request body JSON
+		var skill service.SkillDefinition
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&skill)
+		if err != nil {
+			log.Warnf("route_Skill_PUT() --> json.Decode(&skill): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+		skill.Name = service.Skill(rp.ByName("name"))
+
+		err = dso.Store.UpdateSkill(skill)
+		if err != nil {
+			log.Warnf("route_Skill_PUT() --> Store.UpdateSkill(skill): %v", err)
+			dso.Renderer.JSON(w, skillErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not update skill: %v", errors.Cause(err))})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, skill)
+	}
+}
+
+// route_Skill_DELETE unregisters a skill, provided nothing uses it
+func route_Skill_DELETE(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Skill_DELETE(): Started")
+
+		err := dso.Store.DeleteSkill(service.Skill(rp.ByName("name")))
+		if err != nil {
+			log.Warnf("route_Skill_DELETE() --> Store.DeleteSkill(%q): %v", rp.ByName("name"), err)
+			dso.Renderer.JSON(w, skillErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not delete skill: %v", errors.Cause(err))})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, nil)
+	}
+}
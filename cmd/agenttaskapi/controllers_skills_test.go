@@ -0,0 +1,338 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/unrolled/render"
+)
+
+func Test_route_Skills(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	tests := []struct {
+		name                 string          // Test name
+		method               string          // HTTP method
+		skillName            string          // :name URL parameter, if any
+		body                 string          // HTTP request body
+		wantStatus           int             // Expected HTTP response code
+		wantResponseContains []string        // For validating responses
+		wantSkills           []service.Skill // Expected registered skills after HTTP request is complete
+	}{
+		{
+			name:                 "List returns seed skills",
+			method:               "GET",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"name":"skill1"`, `"name":"skill3"`},
+			wantSkills:           []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:       "Create registers a new skill",
+			method:     "POST",
+			body:       `{"name":"billing","description":"Billing enquiries"}`,
+			wantStatus: http.StatusCreated,
+			wantSkills: []service.Skill{"billing", service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:                 "Create rejects a duplicate skill",
+			method:               "POST",
+			body:                 `{"name":"skill1"}`,
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"already exists"},
+			wantSkills:           []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:       "Create rejects a malformed name",
+			method:     "POST",
+			body:       `{"name":" spaced "}`,
+			wantStatus: http.StatusBadRequest,
+			wantSkills: []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:                 "Get returns a single skill",
+			method:               "GET",
+			skillName:            "skill2",
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"name":"skill2"`},
+			wantSkills:           []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:       "Get unknown skill is not found",
+			method:     "GET",
+			skillName:  "nope",
+			wantStatus: http.StatusNotFound,
+			wantSkills: []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:                 "Update replaces the description",
+			method:               "PUT",
+			skillName:            "skill3",
+			body:                 `{"description":"Escalations"}`,
+			wantStatus:           http.StatusOK,
+			wantResponseContains: []string{`"description":"Escalations"`},
+			wantSkills:           []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:                 "Delete refuses a skill an agent possesses",
+			method:               "DELETE",
+			skillName:            "skill1",
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"in use"},
+			wantSkills:           []service.Skill{service.Skill1, service.Skill2, service.Skill3},
+		},
+		{
+			name:       "Delete removes an unused skill",
+			method:     "DELETE",
+			skillName:  "skill3",
+			wantStatus: http.StatusOK,
+			wantSkills: []service.Skill{service.Skill1, service.Skill2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			store := service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{}},
+			}, nil)
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    store,
+			}
+
+			// Build test request
+			path := "/skills"
+			params := httprouter.Params{}
+			if tt.skillName != "" {
+				path += "/" + tt.skillName
+				params = append(params, httprouter.Param{Key: "name", Value: tt.skillName})
+			}
+			r, err := http.NewRequest(tt.method, path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			var handler httprouter.Handle
+			switch {
+			case tt.method == "GET" && tt.skillName == "":
+				handler = route_Skills(dso)
+			case tt.method == "GET":
+				handler = route_Skill(dso)
+			case tt.method == "POST":
+				handler = route_Skills_New_POST(dso)
+			case tt.method == "PUT":
+				handler = route_Skill_PUT(dso)
+			case tt.method == "DELETE":
+				handler = route_Skill_DELETE(dso)
+			}
+			handler(w, r, params)
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			for _, want := range tt.wantResponseContains {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			skills, err := store.ListSkills()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotSkills := []service.Skill{}
+			for _, sd := range skills {
+				gotSkills = append(gotSkills, sd.Name)
+			}
+			assert.Equal(t, tt.wantSkills, gotSkills)
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_Skills(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+	}, nil)
+	dso := &DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+		return w
+	}
+
+	// Unregistered skill is rejected
+	w := post(`{"priority":"high","required_skills":["billing"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid Skill: billing")
+
+	// Once registered (and possessed by an agent), the skill is assignable
+	err := store.AddSkill(service.SkillDefinition{Name: "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddAgents([]*service.Agent{
+		&service.Agent{Name: "Betty", Skills: service.Skills{"billing"}, Tasks: []*service.Task{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = post(`{"priority":"high","required_skills":["billing"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"Betty"`)
+}
+
+func Test_route_Tasks_New_POST_SkillLevels(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// All idle: Adam is an expert in skill1, Betty a novice and Charlie competent
+	buildStore := func() *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, SkillLevels: service.SkillLevels{service.Skill1: 5}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, SkillLevels: service.SkillLevels{service.Skill1: 3}, Tasks: []*service.Task{}},
+		}, nil)
+	}
+
+	tests := []struct {
+		name          string // Test name
+		postBody      string // HTTP request body
+		wantStatus    int    // Expected HTTP response code
+		wantAgentName string // Expected agent assigned the task (for successes)
+	}{
+		{
+			name:          "Least overqualified agent is preferred",
+			postBody:      `{"priority":"high","required_skills":["skill1"],"min_skill_levels":{"skill1":2}}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name:          "Exact match wins without a minimum",
+			postBody:      `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Betty",
+		},
+		{
+			name:          "Only an expert qualifies",
+			postBody:      `{"priority":"high","required_skills":["skill1"],"min_skill_levels":{"skill1":4}}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Adam",
+		},
+		{
+			name:       "Out-of-range level is rejected",
+			postBody:   `{"priority":"high","required_skills":["skill1"],"min_skill_levels":{"skill1":6}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Level for a skill that is not required is rejected",
+			postBody:   `{"priority":"high","required_skills":["skill1"],"min_skill_levels":{"skill2":1}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dso := &DataSourceOrchestration{
+				Renderer: render.New(),
+				Store:    buildStore(),
+			}
+
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantAgentName != "" {
+				assert.Contains(t, w.Body.String(), `"name":"`+tt.wantAgentName+`"`)
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_PreferredSkills(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// All idle and able to take skill1 tasks; Betty is an expert who also has skill2, Charlie also has skill3
+	buildStore := func() *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill1, service.Skill2}, SkillLevels: service.SkillLevels{service.Skill1: 5}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1, service.Skill3}, Tasks: []*service.Task{}},
+		}, nil)
+	}
+
+	tests := []struct {
+		name          string // Test name
+		postBody      string // HTTP request body
+		wantStatus    int    // Expected HTTP response code
+		wantAgentName string // Expected agent assigned the task (for successes)
+	}{
+		{
+			name:          "No preference falls back to the closest match",
+			postBody:      `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Adam",
+		},
+		{
+			name:          "Agent with a preferred skill is boosted",
+			postBody:      `{"priority":"high","required_skills":["skill1"],"preferred_skills":["skill3"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name:          "Preferred skills outweigh proficiency match",
+			postBody:      `{"priority":"high","required_skills":["skill1"],"preferred_skills":["skill2"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Betty",
+		},
+		{
+			name:          "Preferred skill nobody has does not prevent assignment",
+			postBody:      `{"priority":"high","required_skills":["skill3"],"preferred_skills":["skill2"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name:       "Unregistered preferred skill is rejected",
+			postBody:   `{"priority":"high","required_skills":["skill1"],"preferred_skills":["billing"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dso := &DataSourceOrchestration{
+				Renderer: render.New(),
+				Store:    buildStore(),
+			}
+
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantAgentName != "" {
+				assert.Contains(t, w.Body.String(), `"name":"`+tt.wantAgentName+`"`)
+			}
+		})
+	}
+}
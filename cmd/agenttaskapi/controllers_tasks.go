@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// taskErrorStatus maps task lookup, validation and state machine errors to HTTP status
+// codes; any other error (e.g. failing to persist a change) is the server's fault
+func taskErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case service.ErrTaskNotFound:
+		return http.StatusNotFound
+	case service.ErrInvalidOutcome, service.ErrCancelReasonRequired:
+		return http.StatusBadRequest
+	case service.ErrIllegalTransition, service.ErrNoSkilledAgents, service.ErrNoAvailableAgents,
+		service.ErrAlreadyAssigned, service.ErrAgentLacksSkills, service.ErrAgentNotAvailable, service.ErrAgentDeactivated:
+		return http.StatusConflict
+	case service.ErrAgentNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// taskIDParam parses the :id URL parameter
+func taskIDParam(rp httprouter.Params) (uint, error) {
+	id, err := strconv.ParseUint(rp.ByName("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid task ID: %q", rp.ByName("id"))
+	}
+	return uint(id), nil
+}
+
+// taskTransitions maps the action in /tasks/:id/<action> to the Store method performing it
+var taskTransitions = map[string]func(service.Repository, uint, service.ChangeInfo) error{
+	"start":  service.Repository.StartTask,
+	"pause":  service.Repository.PauseTask,
+	"resume": service.Repository.ResumeTask,
+	"cancel": service.Repository.CancelTask,
+	"reopen": service.Repository.ReopenTask,
+}
+
+// changeInfoFromRequest attributes a change to the actor named in the X-Actor
+// header, for the reason given in the (optional) JSON request body
+func changeInfoFromRequest(r *http.Request) (service.ChangeInfo, error) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.Body != nil {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil && err != io.EOF {
+			return service.ChangeInfo{}, fmt.Errorf("JSON decode of request body failed: %v", err)
+		}
+	}
+
+	return service.ChangeInfo{Actor: r.Header.Get("X-Actor"), Reason: body.Reason}, nil
+}
+
+// route_Tasks_Transition_POST moves the task to another state via the named action
+// (start, pause, resume, cancel, reopen); illegal transitions are rejected with HTTP 409
+func route_Tasks_Transition_POST(dso *DataSourceOrchestration, action string) httprouter.Handle {
+	transition := taskTransitions[action]
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_Transition_POST(%s): Started", action)
+
+		taskID, err := taskIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		ci, err := changeInfoFromRequest(r)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		err = transition(dso.Store, taskID, ci)
+		if err != nil {
+			log.Warnf("route_Tasks_Transition_POST(%s) --> Store transition of task %d: %v", action, taskID, err)
+			dso.Renderer.JSON(w, taskErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not %s task: %v", action, err)})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, nil)
+	}
+}
+
+// route_Tasks_Complete_POST completes a task, optionally recording a resolution note
+// and outcome code in the JSON request body alongside the reason
+func route_Tasks_Complete_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_Complete_POST(): Started")
+
+		taskID, err := taskIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse (optional) request body JSON
+		var body struct {
+			Reason     string              `json:"reason"`
+			Resolution string              `json:"resolution"`
+			Outcome    service.TaskOutcome `json:"outcome"`
+		}
+		if r.Body != nil {
+			err = json.NewDecoder(r.Body).Decode(&body)
+			if err != nil && err != io.EOF {
+				log.Warnf("route_Tasks_Complete_POST() --> json.Decode(&body): %v", err)
+				dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+				return
+			}
+		}
+
+		err = dso.Store.CompleteTaskWithOptions(taskID, service.CompletionOptions{
+			ChangeInfo: service.ChangeInfo{Actor: r.Header.Get("X-Actor"), Reason: body.Reason},
+			Resolution: body.Resolution,
+			Outcome:    body.Outcome,
+		})
+		if err != nil {
+			log.Warnf("route_Tasks_Complete_POST() --> Store.CompleteTaskWithOptions(%d): %v", taskID, err)
+			dso.Renderer.JSON(w, taskErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not complete task: %v", err)})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, nil)
+	}
+}
+
+// route_Task returns a single task by ID, whether active (with its assigned agent), waiting, completed or cancelled
+func route_Task(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Task(): Started")
+
+		taskID, err := taskIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		task, err := dso.Store.GetTask(taskID)
+		if err != nil {
+			dso.Renderer.JSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, task)
+	}
+}
+
+// completedTaskQueryFromRequest builds a completed task query from the URL query string
+func completedTaskQueryFromRequest(r *http.Request) (service.CompletedTaskQuery, error) {
+	params := r.URL.Query()
+	q := service.CompletedTaskQuery{
+		Priority: service.Priority(params.Get("priority")),
+		Skill:    service.Skill(params.Get("skill")),
+		Cursor:   params.Get("cursor"),
+	}
+
+	var err error
+	if v := params.Get("agent_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("Invalid agent_id: %q", v)
+		}
+		q.AgentID = uint(id)
+	}
+	if q.Priority != "" {
+		err = q.Priority.IsValid()
+		if err != nil {
+			return q, err
+		}
+	}
+	for name, dest := range map[string]*time.Time{"completed_after": &q.CompletedAfter, "completed_before": &q.CompletedBefore} {
+		if v := params.Get(name); v != "" {
+			*dest, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s (expected RFC 3339, e.g. 2006-01-02T15:04:05Z): %q", name, v)
+			}
+		}
+	}
+	q.SortBy, q.Descending, err = service.ParseCompletedTaskSort(params.Get("sort"))
+	if err != nil {
+		return q, err
+	}
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("Invalid limit: %q", v)
+		}
+	}
+
+	return q, nil
+}
+
+// route_Tasks_Completed lists a page of completed tasks, filtered and sorted per the query string
+func route_Tasks_Completed(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_Completed(): Started")
+
+		q, err := completedTaskQueryFromRequest(r)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		page, err := dso.Store.QueryCompletedTasks(q)
+		if err != nil {
+			log.Warnf("route_Tasks_Completed() --> Store.QueryCompletedTasks(): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, page)
+	}
+}
+
+// route_Tasks_SLA lists the open tasks flagged by the SLA watcher, oldest first: those
+// at risk or breached, or only those with the status given by the status parameter
+func route_Tasks_SLA(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_SLA(): Started")
+
+		status := service.SLAStatus(r.URL.Query().Get("status"))
+		switch status {
+		case service.SLANone, service.SLAOnTrack, service.SLAAtRisk, service.SLABreached:
+		default:
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid status: %v (expected on_track, at_risk or breached)", status)})
+			return
+		}
+
+		tasks, err := dso.Store.ListSLATasks(status)
+		if err != nil {
+			log.Errorf("route_Tasks_SLA() --> Store.ListSLATasks(): %v", err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving tasks from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, tasks)
+	}
+}
+
+// route_Tasks_History lists every recorded event for the task, oldest first
+func route_Tasks_History(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_History(): Started")
+
+		taskID, err := taskIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		events, err := dso.Store.TaskHistory(taskID)
+		if err != nil {
+			dso.Renderer.JSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, events)
+	}
+}
+
+// route_Tasks_Reassign_POST moves a task to another agent. If the request names
+// an agent_id, that agent must have the required skills and be available for the
+// task's priority; otherwise the engine selects an agent other than the current
+// holder, optionally using the strategy given with ?strategy=<name>.
+func route_Tasks_Reassign_POST(dso *DataSourceOrchestration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		log.Tracef("route_Tasks_Reassign_POST(): Started")
+
+		taskID, err := taskIDParam(rp)
+		if err != nil {
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// Parse (optional) request body JSON
+		var body struct {
+			AgentID uint   `json:"agent_id"`
+			Reason  string `json:"reason"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil && err != io.EOF {
+			log.Warnf("route_Tasks_Reassign_POST() --> json.Decode(&body): %v", err)
+			dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("JSON decode of request body failed: %v", err)})
+			return
+		}
+
+		opts := service.ReassignOptions{
+			AgentID:    body.AgentID,
+			ChangeInfo: service.ChangeInfo{Actor: r.Header.Get("X-Actor"), Reason: body.Reason},
+		}
+		if name := r.URL.Query().Get("strategy"); name != "" {
+			opts.Strategy, err = service.LookupAssignmentStrategy(name)
+			if err != nil {
+				log.Warnf("route_Tasks_Reassign_POST() --> service.LookupAssignmentStrategy(%q): %v", name, err)
+				dso.Renderer.JSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v (available: %s)", err, strings.Join(service.AssignmentStrategyNames(), ", "))})
+				return
+			}
+		}
+
+		_, err = dso.Store.ReassignTask(taskID, opts)
+		if err != nil {
+			log.Warnf("route_Tasks_Reassign_POST() --> Store.ReassignTask(%d): %v", taskID, err)
+			dso.Renderer.JSON(w, taskErrorStatus(err), map[string]string{"error": fmt.Sprintf("Could not reassign task: %v", err)})
+			return
+		}
+
+		task, err := dso.Store.FindTaskWithAgent(taskID)
+		if err != nil {
+			log.Errorf("route_Tasks_Reassign_POST() --> Store.FindTaskWithAgent(%d): %v", taskID, err)
+			dso.Renderer.JSON(w, http.StatusInternalServerError, map[string]string{"error": "Error retrieving task from data store"})
+			return
+		}
+
+		dso.Renderer.JSON(w, http.StatusOK, task)
+	}
+}
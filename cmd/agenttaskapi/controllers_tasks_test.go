@@ -0,0 +1,985 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astockwell/ffn/pkg/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/unrolled/render"
+)
+
+func Test_route_Tasks_Transition_POST(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam holds task 1, freshly assigned
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+			&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
+		}},
+	}, nil)
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	// stateOf returns the state of task 1, wherever it is
+	stateOf := func() service.TaskState {
+		if task, err := store.FindTask(1); err == nil {
+			return task.State
+		}
+		if task, err := store.FindPendingTask(1); err == nil {
+			return task.State
+		}
+		completed, _ := store.ListCompletedTasks()
+		for _, task := range completed {
+			if task.ID == 1 {
+				return task.State
+			}
+		}
+		t.Fatal("Task 1 not found")
+		return 0
+	}
+
+	// Steps run in order against the same store
+	steps := []struct {
+		path       string            // POST request path
+		wantStatus int               // Expected HTTP response code
+		wantState  service.TaskState // Expected state of task 1 afterwards
+	}{
+		{"/tasks/1/pause", http.StatusConflict, service.TaskAssigned},
+		{"/tasks/1/start", http.StatusOK, service.TaskInWIP},
+		{"/tasks/1/resume", http.StatusConflict, service.TaskInWIP},
+		{"/tasks/1/pause", http.StatusOK, service.TaskPaused},
+		{"/tasks/1/complete", http.StatusConflict, service.TaskPaused},
+		{"/tasks/1/resume", http.StatusOK, service.TaskInWIP},
+		{"/tasks/1/complete", http.StatusOK, service.TaskComplete},
+		{"/tasks/1/start", http.StatusNotFound, service.TaskComplete},
+		{"/tasks/1/reopen", http.StatusOK, service.TaskAssigned},
+		{"/tasks/1/reopen", http.StatusNotFound, service.TaskAssigned},
+		{"/tasks/9/start", http.StatusNotFound, service.TaskAssigned},
+		{"/tasks/abc/start", http.StatusBadRequest, service.TaskAssigned},
+	}
+	for _, step := range steps {
+		r, err := http.NewRequest("POST", step.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, step.wantStatus, w.Code, "POST %s: %s", step.path, w.Body.String())
+		assert.Equal(t, step.wantState, stateOf(), "POST %s", step.path)
+	}
+}
+
+func Test_taskErrorStatus(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{service.ErrTaskNotFound, http.StatusNotFound},
+		{errors.Wrap(service.ErrTaskNotFound, "s.findTaskWithAgent(taskID)"), http.StatusNotFound},
+		{service.TaskOutcome("all good").IsValid(), http.StatusBadRequest},
+		{service.ErrCancelReasonRequired, http.StatusBadRequest},
+		{service.ErrIllegalTransition, http.StatusConflict},
+		{service.ErrAgentNotFound, http.StatusNotFound},
+		{fmt.Errorf("disk full"), http.StatusInternalServerError}, // e.g. the journal could not be written
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.wantStatus, taskErrorStatus(tt.err), "%v", tt.err)
+	}
+}
+
+func Test_newRouter_StaticTaskRoutes(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+	}, nil)
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, post("/tasks/new", `{"priority":"high","required_skills":["skill1"]}`).Code)
+	assert.Equal(t, http.StatusOK, post("/tasks/complete", `{"id":1}`).Code)
+	assert.Equal(t, http.StatusNotFound, post("/tasks/1", ``).Code)
+}
+
+func Test_route_Task(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam holds task 1, task 2 waits behind it, task 3 is completed and task 4 cancelled
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+			&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+		}},
+	}, []*service.Task{
+		&service.Task{ID: 3, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskComplete},
+	}, &service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued},
+		&service.Task{ID: 4, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued})
+	err := store.CancelTask(4, service.ChangeInfo{Reason: "Duplicate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	tests := []struct {
+		name                 string   // Test name
+		taskID               string   // :id URL parameter
+		wantStatus           int      // Expected HTTP response code
+		wantResponseContains []string // For validating responses
+	}{
+		{"Active task includes its agent", "1", http.StatusOK, []string{`"id":1,`, `"assigned_agent":{"id":1,"name":"Adam"`, `"task_state":0`}},
+		{"Waiting task", "2", http.StatusOK, []string{`"id":2,`, `"task_state":2`}},
+		{"Completed task", "3", http.StatusOK, []string{`"id":3,`, `"task_state":1`}},
+		{"Cancelled task includes its reason", "4", http.StatusOK, []string{`"id":4,`, `"task_state":5`, `"cancel_reason":"Duplicate"`}},
+		{"Unknown task is not found", "9", http.StatusNotFound, nil},
+		{"Malformed ID is rejected", "abc", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest("GET", "/tasks/"+tt.taskID, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			for _, want := range tt.wantResponseContains {
+				assert.Contains(t, w.Body.String(), want)
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_Complete_POST(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam was assigned task 1 an hour and a half ago
+	assigned := time.Now().Add(-90 * time.Minute)
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+			&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP, AssignmentTime: assigned},
+		}},
+	}, nil)
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Outcome codes may not contain spaces
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/tasks/1/complete", `{"outcome":"all good"}`).Code)
+
+	before := time.Now()
+	w := do("POST", "/tasks/1/complete", `{"resolution":"Reset the customer's password","outcome":"resolved"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The completed-task API reports when and how it was completed, and how long it took
+	w = do("GET", "/tasks/completed", ``)
+	var page service.CompletedTaskPage
+	err := json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(page.Tasks)) {
+		task := page.Tasks[0]
+		assert.False(t, task.CompletedTime.Before(before))
+		assert.InDelta(t, 90*60, task.DurationSeconds, 5)
+		assert.Equal(t, "Reset the customer's password", task.Resolution)
+		assert.Equal(t, service.TaskOutcome("resolved"), task.Outcome)
+	}
+
+	// Reopening discards the completion details
+	assert.Equal(t, http.StatusOK, do("POST", "/tasks/1/reopen", ``).Code)
+	task, err := store.GetTask(1)
+	if assert.NoError(t, err) {
+		assert.True(t, task.CompletedTime.IsZero())
+		assert.Equal(t, float64(0), task.DurationSeconds)
+		assert.Equal(t, "", task.Resolution)
+		assert.Equal(t, service.TaskOutcome(""), task.Outcome)
+	}
+}
+
+func Test_route_Tasks_Completed(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Five completed tasks, an hour apart; task 3 was assigned earliest
+	base := time.Date(2020, 3, 1, 9, 0, 0, 0, time.UTC)
+	adam := &service.Agent{ID: 1, Name: "Adam"}
+	betty := &service.Agent{ID: 2, Name: "Betty"}
+	completed := func(id uint, p service.Priority, ss service.Skills, agent *service.Agent, assignedHour, completedHour int) *service.Task {
+		return &service.Task{ID: id, Priority: p, ReqSkills: ss, AssignedAgent: agent, State: service.TaskComplete,
+			AssignmentTime: base.Add(time.Duration(assignedHour) * time.Hour),
+			CompletedTime:  base.Add(time.Duration(completedHour) * time.Hour),
+		}
+	}
+	store := service.NewStore(nil, []*service.Task{
+		completed(1, service.PriorityHigh, service.Skills{service.Skill1}, adam, 0, 1),
+		completed(2, service.PriorityLow, service.Skills{service.Skill2}, betty, 1, 2),
+		completed(3, service.PriorityHigh, service.Skills{service.Skill1, service.Skill2}, betty, -1, 3),
+		completed(4, service.PriorityLow, service.Skills{service.Skill1}, adam, 3, 4),
+		completed(5, service.PriorityHigh, service.Skills{service.Skill3}, adam, 4, 5),
+	})
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	get := func(query string) (int, service.CompletedTaskPage) {
+		r, err := http.NewRequest("GET", "/tasks/completed?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		var page service.CompletedTaskPage
+		if w.Code == http.StatusOK {
+			err = json.Unmarshal(w.Body.Bytes(), &page)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, page
+	}
+	ids := func(page service.CompletedTaskPage) []uint {
+		got := []uint{}
+		for _, task := range page.Tasks {
+			got = append(got, task.ID)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name       string // Test name
+		query      string // URL query string
+		wantStatus int    // Expected HTTP response code
+		wantIDs    []uint // Expected task IDs, in order
+	}{
+		{"Defaults to completion order, oldest first", "", http.StatusOK, []uint{1, 2, 3, 4, 5}},
+		{"Filters by agent", "agent_id=1", http.StatusOK, []uint{1, 4, 5}},
+		{"Filters by priority", "priority=low", http.StatusOK, []uint{2, 4}},
+		{"Filters by required skill", "skill=skill2", http.StatusOK, []uint{2, 3}},
+		{"Filters by completion time range", "completed_after=2020-03-01T11:00:00Z&completed_before=2020-03-01T13:00:00Z", http.StatusOK, []uint{2, 3}},
+		{"Combines filters", "agent_id=1&priority=high", http.StatusOK, []uint{1, 5}},
+		{"Sorts by assignment time", "sort=assignment_time", http.StatusOK, []uint{3, 1, 2, 4, 5}},
+		{"Sorts in descending order", "sort=-completed_time", http.StatusOK, []uint{5, 4, 3, 2, 1}},
+		{"Rejects an unknown sort", "sort=priority", http.StatusBadRequest, nil},
+		{"Rejects an unconfigured priority", "priority=urgent", http.StatusBadRequest, nil},
+		{"Rejects a malformed time", "completed_after=yesterday", http.StatusBadRequest, nil},
+		{"Rejects a malformed cursor", "cursor=nope", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, page := get(tt.query)
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.wantIDs, ids(page))
+				assert.Equal(t, "", page.NextCursor)
+			}
+		})
+	}
+
+	t.Run("Pages follow the cursor", func(t *testing.T) {
+		got := []uint{}
+		query := "sort=-assignment_time&limit=2"
+		for pages := 0; pages < 5; pages++ {
+			status, page := get(query)
+			if !assert.Equal(t, http.StatusOK, status) {
+				return
+			}
+			got = append(got, ids(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			query = "sort=-assignment_time&limit=2&cursor=" + page.NextCursor
+		}
+		assert.Equal(t, []uint{5, 4, 2, 1, 3}, got)
+	})
+}
+
+func Test_route_Tasks_History(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+	}, nil)
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	do := func(method, path, actor, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Actor", actor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Create, work on, complete and reopen a task
+	assert.Equal(t, http.StatusCreated, do("POST", "/tasks/new", "dispatcher", `{"priority":"high","required_skills":["skill1"]}`).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/tasks/1/start", "adam", ``).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/tasks/1/complete", "adam", ``).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/tasks/1/reopen", "supervisor", `{"reason":"Customer still affected"}`).Code)
+
+	w := do("GET", "/tasks/1/history", "", ``)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var events []service.TaskEvent
+	err := json.Unmarshal(w.Body.Bytes(), &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		Kind    service.TaskEventKind
+		From    service.TaskState
+		To      service.TaskState
+		AgentID uint
+		Actor   string
+		Reason  string
+	}
+	got := []summary{}
+	for _, ev := range events {
+		assert.False(t, ev.Time.IsZero())
+		got = append(got, summary{ev.Kind, ev.FromState, ev.ToState, ev.AgentID, ev.Actor, ev.Reason})
+	}
+	assert.Equal(t, []summary{
+		{service.TaskEventCreated, service.TaskQueued, service.TaskQueued, 0, "dispatcher", ""},
+		{service.TaskEventAssigned, service.TaskQueued, service.TaskAssigned, 1, "dispatcher", "Selected by standard strategy"},
+		{service.TaskEventStateChanged, service.TaskAssigned, service.TaskInWIP, 1, "adam", ""},
+		{service.TaskEventStateChanged, service.TaskInWIP, service.TaskComplete, 0, "adam", ""},
+		{service.TaskEventReopened, service.TaskComplete, service.TaskReopened, 0, "supervisor", "Customer still affected"},
+		{service.TaskEventAssigned, service.TaskReopened, service.TaskAssigned, 1, service.ActorSystem, "Selected by standard strategy"},
+	}, got)
+
+	// Unknown tasks have no history
+	assert.Equal(t, http.StatusNotFound, do("GET", "/tasks/9/history", "", ``).Code)
+}
+
+func Test_route_Tasks_Cancel_POST(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam is working on task 1; task 2 waits behind it for the only skilled agent
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+			&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+		}},
+	}, nil, &service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued})
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Actor", "supervisor")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// A reason is required
+	assert.Equal(t, http.StatusBadRequest, post("/tasks/1/cancel", ``).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/tasks/1/cancel", `{"reason":" "}`).Code)
+
+	// Cancelling Adam's task frees him for the waiting one
+	w := post("/tasks/1/cancel", `{"reason":"Customer withdrew the request"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err := store.FindTaskWithAgent(2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Adam", task.AssignedAgent.Name)
+	}
+
+	// Cancelled tasks are kept apart from completed ones, with their reason
+	cancelled, _ := store.ListCancelledTasks()
+	if assert.Equal(t, 1, len(cancelled)) {
+		assert.Equal(t, uint(1), cancelled[0].ID)
+		assert.Equal(t, service.TaskCancelled, cancelled[0].State)
+		assert.Equal(t, "Customer withdrew the request", cancelled[0].CancelReason)
+	}
+	completed, _ := store.ListCompletedTasks()
+	assert.Equal(t, 0, len(completed))
+	events, _ := store.TaskHistory(1)
+	last := events[len(events)-1]
+	assert.Equal(t, service.TaskCancelled, last.ToState)
+	assert.Equal(t, "supervisor", last.Actor)
+	assert.Equal(t, "Customer withdrew the request", last.Reason)
+
+	// Only reopening is possible once cancelled
+	assert.Equal(t, http.StatusNotFound, post("/tasks/1/cancel", `{"reason":"Again"}`).Code)
+	assert.Equal(t, http.StatusOK, post("/tasks/1/reopen", ``).Code)
+	reopened, err := store.FindPendingTask(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.TaskReopened, reopened.State)
+		assert.Equal(t, "", reopened.CancelReason)
+	}
+
+	// Waiting tasks may be cancelled too
+	assert.Equal(t, http.StatusOK, post("/tasks/1/cancel", `{"reason":"Duplicate"}`).Code)
+	pending, _ := store.ListPendingTasks()
+	assert.Equal(t, 0, len(pending))
+	cancelled, _ = store.ListCancelledTasks()
+	if assert.Equal(t, 1, len(cancelled)) {
+		assert.Equal(t, "Duplicate", cancelled[0].CancelReason)
+	}
+}
+
+func Test_route_Tasks_Reassign_POST(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam is working on task 1 and Dana on task 2; Charlie is the only other
+	// skilled agent free to take either, as Betty lacks the skill and Eve is deactivated
+	buildStore := func() *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+				&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+			}},
+			&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+			&service.Agent{Name: "Dana", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+				&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
+			}},
+			&service.Agent{Name: "Eve", Skills: service.Skills{service.Skill1}, Deactivated: true, Tasks: []*service.Task{}},
+		}, nil)
+	}
+
+	tests := []struct {
+		name                 string               // Test name
+		setup                func(*service.Store) // Adjusts the store prior to HTTP request, if set
+		taskID               string               // :id URL parameter
+		body                 string               // HTTP request body
+		wantStatus           int                  // Expected HTTP response code
+		wantResponseContains []string             // For validating errors
+		wantHolder           string               // Expected agent holding the task afterwards
+	}{
+		{
+			name:       "Named agent takes the task",
+			taskID:     "1",
+			body:       `{"agent_id":3,"reason":"Adam is going home"}`,
+			wantStatus: http.StatusOK,
+			wantHolder: "Charlie",
+		},
+		{
+			name:                 "Named agent without the required skills is rejected",
+			taskID:               "1",
+			body:                 `{"agent_id":2}`,
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"does not possess the required skills"},
+			wantHolder:           "Adam",
+		},
+		{
+			name:                 "Named agent busy with a task of equal rank is rejected",
+			taskID:               "1",
+			body:                 `{"agent_id":4}`,
+			wantStatus:           http.StatusConflict,
+			wantResponseContains: []string{"not currently available"},
+			wantHolder:           "Adam",
+		},
+		{
+			name:       "Naming the current holder is rejected",
+			taskID:     "1",
+			body:       `{"agent_id":1}`,
+			wantStatus: http.StatusConflict,
+			wantHolder: "Adam",
+		},
+		{
+			name:       "Naming a deactivated agent is rejected",
+			taskID:     "1",
+			body:       `{"agent_id":5}`,
+			wantStatus: http.StatusConflict,
+			wantHolder: "Adam",
+		},
+		{
+			name:       "Naming an unknown agent is not found",
+			taskID:     "1",
+			body:       `{"agent_id":99}`,
+			wantStatus: http.StatusNotFound,
+			wantHolder: "Adam",
+		},
+		{
+			name:       "Engine selects an agent other than the current holder",
+			taskID:     "2",
+			wantStatus: http.StatusOK,
+			wantHolder: "Charlie",
+		},
+		{
+			name: "Engine fails when no other agent is available",
+			setup: func(s *service.Store) {
+				err := s.DeactivateAgent(3, service.InFlightBlock)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			taskID:     "2",
+			wantStatus: http.StatusConflict,
+			wantHolder: "Dana",
+		},
+		{
+			name:       "Unknown task is not found",
+			taskID:     "9",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			store := buildStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			router := newRouter(&DataSourceOrchestration{
+				Renderer: render.New(),
+				Store:    store,
+			})
+
+			// Execute test request
+			r, err := http.NewRequest("POST", "/tasks/"+tt.taskID+"/reassign", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			for _, want := range tt.wantResponseContains {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			if tt.wantHolder != "" {
+				id, _ := strconv.Atoi(tt.taskID)
+				task, err := store.FindTaskWithAgent(uint(id))
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.wantHolder, task.AssignedAgent.Name)
+					if tt.wantStatus == http.StatusOK {
+						assert.Equal(t, service.TaskAssigned, task.State)
+
+						events, _ := store.TaskHistory(uint(id))
+						last := events[len(events)-1]
+						assert.Equal(t, service.TaskEventReassigned, last.Kind)
+						assert.Equal(t, task.AssignedAgent.ID, last.AgentID)
+						assert.NotEqual(t, last.AgentID, last.FromAgentID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_SLA(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Betty is away, so task 2 waits; task 3 has no SLA target, only a distant deadline
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2}, Presence: service.PresenceAway, Tasks: []*service.Task{}},
+	}, nil)
+	err := store.SetSLAPolicy(service.SLAPolicy{Targets: map[service.Priority]service.SLATarget{
+		service.PriorityHigh: {TimeToAssignSeconds: 60, TimeToCompleteSeconds: 3600},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for _, task := range []*service.Task{
+		&service.Task{Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}},
+		&service.Task{Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill2}},
+		&service.Task{Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, Deadline: start.Add(10 * time.Hour)},
+	} {
+		_, _, err = store.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, service.SLAOnTrack, task.SLAStatus)
+	}
+
+	// Task 2 misses its time to assign; later, task 1 nears its time to complete
+	err = store.CheckSLAs(start.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.CheckSLAs(start.Add(50 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+
+	tests := []struct {
+		name       string // Test name
+		path       string // Request path
+		wantStatus int    // Expected HTTP response code
+		wantIDs    []uint // Expected tasks listed, in order
+	}{
+		{"Flagged tasks are listed oldest first", "/tasks/sla", http.StatusOK, []uint{1, 2}},
+		{"Breached tasks only", "/tasks/sla?status=breached", http.StatusOK, []uint{2}},
+		{"On-track tasks only", "/tasks/sla?status=on_track", http.StatusOK, []uint{3}},
+		{"Unknown status is rejected", "/tasks/sla?status=late", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest("GET", tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantIDs == nil {
+				return
+			}
+			var gotTasks []service.Task
+			err = json.Unmarshal(w.Body.Bytes(), &gotTasks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotIDs := []uint{}
+			for _, task := range gotTasks {
+				gotIDs = append(gotIDs, task.ID)
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+		})
+	}
+
+	// The status is part of the task, and each change is in its history
+	task, err := store.GetTask(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.SLAAtRisk, task.SLAStatus)
+	}
+	events, err := store.TaskHistory(2)
+	if assert.NoError(t, err) && assert.NotEmpty(t, events) {
+		last := events[len(events)-1]
+		assert.Equal(t, service.TaskEventSLAChanged, last.Kind)
+		assert.Equal(t, string(service.SLABreached), last.Reason)
+	}
+
+	// Time to assign is met by the first assignment, however much later the task is reassigned
+	policy := store.SLAPolicy()
+	reassigned := service.Task{
+		Priority:          service.PriorityHigh,
+		State:             service.TaskAssigned,
+		CreatedTime:       start,
+		FirstAssignedTime: start.Add(30 * time.Second),
+		AssignmentTime:    start.Add(5 * time.Minute),
+	}
+	assert.Equal(t, service.SLAOnTrack, policy.Evaluate(&reassigned, start.Add(10*time.Minute)))
+
+	// Completing a task rates it against its targets for good
+	err = store.CompleteTask(1, service.ChangeInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = store.GetTask(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.SLAMet, task.SLAStatus)
+	}
+
+	// Reopening it holds it to its targets afresh; Adam, now free, takes it straight back
+	err = store.ReopenTask(1, service.ChangeInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = store.GetTask(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.SLAOnTrack, task.SLAStatus)
+		assert.False(t, task.ReopenedTime.IsZero())
+		assert.False(t, task.FirstAssignedTime.Before(task.ReopenedTime))
+	}
+	reopened := service.Task{
+		Priority:          service.PriorityHigh,
+		State:             service.TaskAssigned,
+		CreatedTime:       start,
+		ReopenedTime:      start.Add(2 * time.Hour),
+		FirstAssignedTime: start.Add(2*time.Hour + 30*time.Second),
+	}
+	assert.Equal(t, service.SLAOnTrack, policy.Evaluate(&reopened, start.Add(2*time.Hour+10*time.Minute)))
+}
+
+func Test_route_Tasks_Escalation(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	levels, err := service.ParsePriorityLevels("urgent,high,normal,low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.ConfigurePriorities(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.ConfigurePriorities(service.DefaultPriorityLevels())
+
+	// Adam takes task 1, so low task 2 waits behind it; Sam is a supervisor, outside the normal skill pool
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Sam", Tasks: []*service.Task{}},
+	}, nil)
+	start := time.Now()
+	for _, task := range []*service.Task{
+		&service.Task{Priority: "high", ReqSkills: service.Skills{service.Skill1}},
+		&service.Task{Priority: "low", ReqSkills: service.Skills{service.Skill1}},
+	} {
+		_, _, err = store.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Invalid rules are rejected
+	for _, rule := range []service.EscalationRule{
+		{Name: "no-action", State: service.TaskQueued, AfterSeconds: 60},
+		{Name: "no-pool", State: service.TaskQueued, AfterSeconds: 60, Action: service.EscalateReroute},
+		{Name: "finished", State: service.TaskComplete, AfterSeconds: 60, Action: service.EscalateRaisePriority},
+		{Name: "immediate", State: service.TaskQueued, Action: service.EscalateRaisePriority},
+	} {
+		assert.Error(t, store.SetEscalationRules([]service.EscalationRule{rule}), rule.Name)
+	}
+	err = store.SetEscalationRules([]service.EscalationRule{
+		{Name: "stale-low", State: service.TaskQueued, AfterSeconds: 600, Priority: "low", Action: service.EscalateRaisePriority},
+		{Name: "unstarted", State: service.TaskAssigned, AfterSeconds: 3600, Action: service.EscalateReroute, PoolAgentIDs: []uint{2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(&DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	lastEvent := func(taskID uint) service.TaskEvent {
+		events, err := store.TaskHistory(taskID)
+		if err != nil || len(events) == 0 {
+			t.Fatalf("No history for task %d: %v", taskID, err)
+		}
+		return events[len(events)-1]
+	}
+
+	w := get("/escalations")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"stale-low"`)
+
+	// After 11 minutes waiting, task 2 is raised one level, but still waits behind task 1
+	err = store.Escalate(start.Add(11 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.GetTask(2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.Priority("normal"), task.Priority)
+		assert.Equal(t, service.TaskQueued, task.State)
+	}
+	assert.Equal(t, service.TaskEventEscalated, lastEvent(2).Kind)
+	assert.Equal(t, "stale-low", lastEvent(2).Reason)
+
+	// A rule fires only once per stay in a state
+	before, _ := store.TaskHistory(2)
+	err = store.Escalate(start.Add(12 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := store.TaskHistory(2)
+	assert.Equal(t, len(before), len(after))
+
+	// After an hour unstarted, task 1 is rerouted to Sam, and Adam picks up task 2
+	err = store.Escalate(start.Add(61 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = get("/tasks/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"assigned_agent":{"id":2,"name":"Sam"`)
+	event := lastEvent(1)
+	assert.Equal(t, service.TaskEventEscalated, event.Kind)
+	assert.Equal(t, "unstarted", event.Reason)
+	assert.Equal(t, uint(1), event.FromAgentID)
+	assert.Equal(t, uint(2), event.AgentID)
+	task, err = store.GetTask(2)
+	if assert.NoError(t, err) && assert.NotNil(t, task.AssignedAgent) {
+		assert.Equal(t, "Adam", task.AssignedAgent.Name)
+	}
+}
+
+func Test_route_Tasks_Escalation_RerouteMeetsTimeToAssign(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam is away, so the task waits until it is rerouted to Sam, a supervisor
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Presence: service.PresenceAway, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Sam", Tasks: []*service.Task{}},
+	}, nil)
+	err := store.SetSLAPolicy(service.SLAPolicy{Targets: map[service.Priority]service.SLATarget{
+		service.PriorityLow: {TimeToAssignSeconds: 600},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetEscalationRules([]service.EscalationRule{
+		{Name: "waiting", State: service.TaskQueued, AfterSeconds: 60, Action: service.EscalateReroute, PoolAgentIDs: []uint{2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, _, err = store.AddTaskToAgent(&service.Task{Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rerouted after 2 minutes, the task met its 10 minute time to assign for good
+	err = store.Escalate(start.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.CheckSLAs(start.Add(20 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.GetTask(1)
+	if assert.NoError(t, err) && assert.NotNil(t, task.AssignedAgent) {
+		assert.Equal(t, "Sam", task.AssignedAgent.Name)
+		assert.Equal(t, start.Add(2*time.Minute).Unix(), task.FirstAssignedTime.Unix())
+		assert.Equal(t, service.SLAOnTrack, task.SLAStatus)
+	}
+}
+
+func Test_route_Tasks_Escalation_HeldTasks(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	levels, err := service.ParsePriorityLevels("urgent,high,normal,low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.ConfigurePriorities(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.ConfigurePriorities(service.DefaultPriorityLevels())
+
+	// Each agent takes a low task, then a more urgent one in front of it; Charlie has started his low task
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2}, Tasks: []*service.Task{}},
+		&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill3}, Tasks: []*service.Task{}},
+	}, nil)
+	start := time.Now()
+	for _, task := range []*service.Task{
+		&service.Task{Priority: "low", ReqSkills: service.Skills{service.Skill1}},
+		&service.Task{Priority: "high", ReqSkills: service.Skills{service.Skill1}},
+		&service.Task{Priority: "low", ReqSkills: service.Skills{service.Skill2}},
+		&service.Task{Priority: "normal", ReqSkills: service.Skills{service.Skill2}},
+		&service.Task{Priority: "low", ReqSkills: service.Skills{service.Skill3}},
+	} {
+		_, _, err = store.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.StartTask(5, service.ChangeInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = store.AddTaskToAgent(&service.Task{Priority: "high", ReqSkills: service.Skills{service.Skill3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetEscalationRules([]service.EscalationRule{
+		{Name: "stuck-low", State: service.TaskAssigned, AfterSeconds: 600, Priority: "low", Action: service.EscalateRaisePriority, ToPriority: "high"},
+		{Name: "slow-low", State: service.TaskInWIP, AfterSeconds: 600, Priority: "low", Action: service.EscalateRaisePriority, ToPriority: "high"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := func(agentID uint) []uint {
+		agent, err := store.FindAgent(agentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []uint{}
+		for _, task := range agent.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []uint{2, 1}, queue(1))
+	assert.Equal(t, []uint{4, 3}, queue(2))
+	assert.Equal(t, []uint{6, 5}, queue(3))
+
+	err = store.Escalate(start.Add(11 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Raised to high, task 1 would join Adam's high task 2, so it goes back to the
+	// pending queue, where it waits as Adam is the only skilled agent
+	assert.Equal(t, []uint{2}, queue(1))
+	task, err := store.FindPendingTask(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.Priority("high"), task.Priority)
+		assert.Equal(t, service.TaskQueued, task.State)
+	}
+
+	// Raised to high, task 3 now outranks Betty's normal task 4, so it moves in front of it
+	assert.Equal(t, []uint{3, 4}, queue(2))
+	task, err = store.GetTask(3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.Priority("high"), task.Priority)
+		assert.Equal(t, service.TaskAssigned, task.State)
+	}
+
+	// Charlie has begun task 5, so it is not raised while he holds high task 6
+	assert.Equal(t, []uint{6, 5}, queue(3))
+	task, err = store.GetTask(5)
+	if assert.NoError(t, err) {
+		assert.Equal(t, service.Priority("low"), task.Priority)
+	}
+	events, _ := store.TaskHistory(5)
+	assert.NotEqual(t, service.TaskEventEscalated, events[len(events)-1].Kind)
+}
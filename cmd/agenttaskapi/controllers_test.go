@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -18,7 +20,7 @@ import (
 
 func buildSeededTestStore(t *testing.T) *service.Store {
 	// Setup data store
-	store := &service.Store{}
+	store := service.NewStore(nil, nil)
 
 	// Seed data store
 	agents := service.BuildSeedAgents()
@@ -30,6 +32,22 @@ func buildSeededTestStore(t *testing.T) *service.Store {
 	return store
 }
 
+// firstPickSource is a rand.Source that always yields 0, so that random agent
+// selection deterministically picks the first candidate
+type firstPickSource struct{}
+
+func (firstPickSource) Int63() int64 { return 0 }
+func (firstPickSource) Seed(int64)   {}
+
+// TestMain makes random agent selection pick the first candidate for every test,
+// restoring the previous source afterwards
+func TestMain(m *testing.M) {
+	previous := service.SetRandomSource(firstPickSource{})
+	code := m.Run()
+	service.SetRandomSource(previous)
+	os.Exit(code)
+}
+
 func Test_route_Tasks_New_POST(t *testing.T) {
 	log.SetLevel(log.ErrorLevel)
 	// log.SetFormatter(&prefixed.TextFormatter{FullTimestamp: true, TimestampFormat: "2006/01/02 15:04:05", ForceFormatting: true})
@@ -45,11 +63,11 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 		Skills []string `json:"skills"`
 	}
 	type testResponse struct {
-		ID             uint              `json:"id"`
-		Priority       string            `json:"priority"`
-		RequiredSkills []string          `json:"required_skills"`
-		AssignedAgent  testResponseAgent `json:"assigned_agent"`
-		TaskState      int               `json:"task_state"`
+		ID             uint               `json:"id"`
+		Priority       string             `json:"priority"`
+		RequiredSkills []string           `json:"required_skills"`
+		AssignedAgent  *testResponseAgent `json:"assigned_agent,omitempty"`
+		TaskState      int                `json:"task_state"`
 	}
 
 	tests := []struct {
@@ -74,12 +92,12 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				ID:             1,
 				Priority:       "high",
 				RequiredSkills: []string{"skill1"},
-				AssignedAgent:  testResponseAgent{ID: 1, Name: "Adam", Skills: []string{"skill1", "skill2"}},
-				TaskState:      0,
+				AssignedAgent:  &testResponseAgent{ID: 1, Name: "Adam", Skills: []string{"skill1", "skill2"}},
+				TaskState:      3,
 			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
-					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
 				}},
 				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
 				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}},
@@ -100,8 +118,8 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				ID:             2,
 				Priority:       "high",
 				RequiredSkills: []string{"skill1"},
-				AssignedAgent:  testResponseAgent{ID: 3, Name: "Charlie", Skills: []string{"skill1"}},
-				TaskState:      0,
+				AssignedAgent:  &testResponseAgent{ID: 3, Name: "Charlie", Skills: []string{"skill1"}},
+				TaskState:      3,
 			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
@@ -109,7 +127,7 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				}},
 				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
 				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
-					&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+					&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
 				}},
 			}, nil),
 		},
@@ -130,15 +148,15 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				ID:             3,
 				Priority:       "high",
 				RequiredSkills: []string{"skill3"},
-				AssignedAgent:  testResponseAgent{ID: 2, Name: "Betty", Skills: []string{"skill2", "skill3"}},
-				TaskState:      0,
+				AssignedAgent:  &testResponseAgent{ID: 2, Name: "Betty", Skills: []string{"skill2", "skill3"}},
+				TaskState:      3,
 			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
 					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
 				}},
 				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{
-					&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill3}, State: service.TaskInWIP},
+					&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill3}, State: service.TaskAssigned},
 				}},
 				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
 					&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
@@ -154,7 +172,7 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 			wantStore:            service.NewStore([]*service.Agent{}, nil),
 		},
 		{
-			name: "Assignment fails: no agent available for priority",
+			name: "Assignment queued: no agent available for priority",
 			store: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
 					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
@@ -164,9 +182,14 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 					&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
 				}},
 			}, nil),
-			postBody:             testRequest{Priority: "high", ReqSkills: []string{"skill1"}},
-			wantStatus:           http.StatusConflict,
-			wantResponseContains: []string{`{"error":"Could not assign task: No agents are currently available for this task priority"}`},
+			postBody:   testRequest{Priority: "high", ReqSkills: []string{"skill1"}},
+			wantStatus: http.StatusAccepted,
+			wantResponse: &testResponse{
+				ID:             3,
+				Priority:       "high",
+				RequiredSkills: []string{"skill1"},
+				TaskState:      2,
+			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
 					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
@@ -175,7 +198,7 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
 					&service.Task{ID: 2, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
 				}},
-			}, nil),
+			}, nil, &service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued}),
 		},
 		{
 			name: "Assignment of higher priority proceeds to agent w/ most recently assigned task",
@@ -194,8 +217,8 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				ID:             3,
 				Priority:       "high",
 				RequiredSkills: []string{"skill1"},
-				AssignedAgent:  testResponseAgent{ID: 3, Name: "Charlie", Skills: []string{"skill1"}},
-				TaskState:      0,
+				AssignedAgent:  &testResponseAgent{ID: 3, Name: "Charlie", Skills: []string{"skill1"}},
+				TaskState:      3,
 			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
@@ -203,7 +226,7 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				}},
 				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
 				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
-					&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+					&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
 					&service.Task{ID: 2, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
 				}},
 			}, nil),
@@ -226,12 +249,12 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				ID:             4,
 				Priority:       "high",
 				RequiredSkills: []string{"skill1"},
-				AssignedAgent:  testResponseAgent{ID: 1, Name: "Adam", Skills: []string{"skill1", "skill2"}},
-				TaskState:      0,
+				AssignedAgent:  &testResponseAgent{ID: 1, Name: "Adam", Skills: []string{"skill1", "skill2"}},
+				TaskState:      3,
 			},
 			wantStore: service.NewStore([]*service.Agent{
 				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
-					&service.Task{ID: 4, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+					&service.Task{ID: 4, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
 					&service.Task{ID: 1, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
 				}},
 				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
@@ -272,8 +295,9 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 			// spew.Dump(w))
 			// fmt.Println(w.Body)
 
-			// Reset timestamps from store tasks
-			tt.store.TESTING_resetTaskAssignmentTimes()
+			// Reset timestamps and history from store tasks
+			tt.store.TESTING_resetTimestamps()
+			tt.store.TESTING_resetHistory()
 
 			// Assertions
 			assert.Equal(t, tt.wantStatus, w.Code)
@@ -305,7 +329,9 @@ func Test_route_Tasks_New_POST(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
-				gotTask.AssignmentTime = time.Time{} // Clear HTTP response Task{} assignment timestamp
+				gotTask.AssignmentTime = time.Time{} // Clear HTTP response Task{} timestamps
+				gotTask.FirstAssignedTime = time.Time{}
+				gotTask.CreatedTime = time.Time{}
 
 				assert.Equal(t, wantTask, gotTask)
 			}
@@ -371,6 +397,30 @@ func Test_route_Tasks_Update_Complete_POST(t *testing.T) {
 				&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskComplete, AssignedAgent: &service.Agent{ID: 1, Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}}},
 			}),
 		},
+		{
+			name: "Task completion assigns waiting task from pending queue",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+				}},
+				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
+			}, nil,
+				&service.Task{ID: 2, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued, CreatedTime: time.Now().Add(-2 * time.Minute)},
+				&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued, CreatedTime: time.Now().Add(-1 * time.Minute)},
+			),
+			postBody:   testRequest{ID: 1},
+			wantStatus: http.StatusOK,
+			wantStore: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+					&service.Task{ID: 3, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskAssigned},
+				}},
+				&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2, service.Skill3}, Tasks: []*service.Task{}},
+			}, []*service.Task{
+				&service.Task{ID: 1, Priority: service.PriorityHigh, ReqSkills: service.Skills{service.Skill1}, State: service.TaskComplete, AssignedAgent: &service.Agent{ID: 1, Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}}},
+			},
+				&service.Task{ID: 2, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskQueued},
+			),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -402,8 +452,9 @@ func Test_route_Tasks_Update_Complete_POST(t *testing.T) {
 			// spew.Dump(w))
 			// fmt.Println(w.Body)
 
-			// Reset timestamps from store tasks
-			tt.store.TESTING_resetTaskAssignmentTimes()
+			// Reset timestamps and history from store tasks
+			tt.store.TESTING_resetTimestamps()
+			tt.store.TESTING_resetHistory()
 
 			// Assertions
 			assert.Equal(t, tt.wantStatus, w.Code)
@@ -412,3 +463,745 @@ func Test_route_Tasks_Update_Complete_POST(t *testing.T) {
 		})
 	}
 }
+
+func Test_route_Tasks_New_POST_Strategy(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	tests := []struct {
+		name          string         // Test name
+		store         *service.Store // Initial state of the data store prior to HTTP request
+		strategy      string         // ?strategy= query parameter
+		wantStatus    int            // Expected HTTP response code
+		wantAgentName string         // Expected agent assigned the task (for successes)
+	}{
+		{
+			name: "Default strategy prefers a random idle agent",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, IdleSince: time.Now().Add(-1 * time.Hour), Tasks: []*service.Task{}},
+				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, IdleSince: time.Now().Add(-2 * time.Hour), Tasks: []*service.Task{}},
+			}, nil),
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Adam",
+		},
+		{
+			name: "longest_idle prefers agent idle the longest",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, IdleSince: time.Now().Add(-1 * time.Hour), Tasks: []*service.Task{}},
+				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, IdleSince: time.Now().Add(-2 * time.Hour), Tasks: []*service.Task{}},
+			}, nil),
+			strategy:      "longest_idle",
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name: "least_loaded ignores task recency",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP, AssignmentTime: time.Now().Add(-2 * time.Hour)},
+				}},
+				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 2, Priority: service.PriorityLow, ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP, AssignmentTime: time.Now().Add(-1 * time.Hour)},
+				}},
+			}, nil),
+			strategy:      "least_loaded",
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Adam",
+		},
+		{
+			name:       "Unknown strategy is rejected",
+			store:      service.NewStore([]*service.Agent{}, nil),
+			strategy:   "alphabetical",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    tt.store,
+			}
+
+			// Build test request
+			url := "/tasks/new"
+			if tt.strategy != "" {
+				url += "?strategy=" + tt.strategy
+			}
+			r, err := http.NewRequest("POST", url, strings.NewReader(`{"priority":"high","required_skills":["skill1"]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantAgentName != "" {
+				var gotTask service.Task
+				err = json.Unmarshal(w.Body.Bytes(), &gotTask) // Unmarshal POST HTTP response body --> Task{}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assert.NotNil(t, gotTask.AssignedAgent) {
+					assert.Equal(t, tt.wantAgentName, gotTask.AssignedAgent.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_Priorities(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	levels, err := service.ParsePriorityLevels("urgent,high,normal,low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.ConfigurePriorities(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.ConfigurePriorities(service.DefaultPriorityLevels())
+
+	tests := []struct {
+		name          string         // Test name
+		store         *service.Store // Initial state of the data store prior to HTTP request
+		postBody      string         // HTTP request body
+		wantStatus    int            // Expected HTTP response code
+		wantAgentName string         // Expected agent assigned the task (for successes)
+	}{
+		{
+			name: "Agent holding a lower-ranked task is available",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+				}},
+				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 2, Priority: "normal", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+				}},
+			}, nil),
+			postBody:      `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name: "Agents holding tasks of equal or higher rank are blocked",
+			store: service.NewStore([]*service.Agent{
+				&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 1, Priority: "urgent", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+				}},
+				&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+					&service.Task{ID: 2, Priority: "normal", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+				}},
+			}, nil),
+			postBody:   `{"priority":"normal","required_skills":["skill1"]}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "Unconfigured priority is rejected",
+			store:      service.NewStore([]*service.Agent{}, nil),
+			postBody:   `{"priority":"critical","required_skills":["skill1"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    tt.store,
+			}
+
+			// Build test request
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantAgentName != "" {
+				var gotTask service.Task
+				err = json.Unmarshal(w.Body.Bytes(), &gotTask) // Unmarshal POST HTTP response body --> Task{}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assert.NotNil(t, gotTask.AssignedAgent) {
+					assert.Equal(t, tt.wantAgentName, gotTask.AssignedAgent.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_Capacity(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	levels, err := service.ParsePriorityLevels("urgent,high,normal,low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.ConfigurePriorities(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.ConfigurePriorities(service.DefaultPriorityLevels())
+
+	// Adam (a trainee) and Charlie each hold a low task, which alone would leave them available for anything more urgent
+	buildStore := func(adam, charlie service.Capacity) *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Capacity: adam, Tasks: []*service.Task{
+				&service.Task{ID: 1, Priority: "low", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+			}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Capacity: charlie, Tasks: []*service.Task{
+				&service.Task{ID: 2, Priority: "low", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+			}},
+		}, nil)
+	}
+
+	tests := []struct {
+		name          string         // Test name
+		store         *service.Store // Initial state of the data store prior to HTTP request
+		postBody      string         // HTTP request body
+		wantStatus    int            // Expected HTTP response code
+		wantAgentName string         // Expected agent assigned the task (for successes)
+	}{
+		{
+			name:          "Agent at max_tasks is skipped",
+			store:         buildStore(service.Capacity{MaxTasks: 1}, service.Capacity{}),
+			postBody:      `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name:       "Task is queued when every agent is at capacity",
+			store:      buildStore(service.Capacity{MaxTasks: 1}, service.Capacity{MaxTasks: 1}),
+			postBody:   `{"priority":"urgent","required_skills":["skill1"]}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:          "Priority limit overrides max_tasks for that priority",
+			store:         buildStore(service.Capacity{MaxTasks: 1, MaxTasksByPriority: map[service.Priority]int{"urgent": 2}}, service.Capacity{MaxTasks: 1}),
+			postBody:      `{"priority":"urgent","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Adam",
+		},
+		{
+			name:       "Priority limit applies only to its own priority",
+			store:      buildStore(service.Capacity{MaxTasks: 1, MaxTasksByPriority: map[service.Priority]int{"urgent": 2}}, service.Capacity{MaxTasks: 1}),
+			postBody:   `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus: http.StatusAccepted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    tt.store,
+			}
+
+			// Build test request
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantAgentName != "" {
+				var gotTask service.Task
+				err = json.Unmarshal(w.Body.Bytes(), &gotTask) // Unmarshal POST HTTP response body --> Task{}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assert.NotNil(t, gotTask.AssignedAgent) {
+					assert.Equal(t, tt.wantAgentName, gotTask.AssignedAgent.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_CapacityStacking(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam, a senior agent, may hold five tasks of any priority
+	store := service.NewStore([]*service.Agent{
+		&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Capacity: service.Capacity{MaxTasks: 5}, Tasks: []*service.Task{}},
+	}, nil)
+	dso := &DataSourceOrchestration{
+		Renderer: render.New(),
+		Store:    store,
+	}
+	post := func(priority string) int {
+		r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(`{"priority":"`+priority+`","required_skills":["skill1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+		w := httptest.NewRecorder()
+		route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+		return w.Code
+	}
+
+	// Four low tasks stack up on Adam, and a high task goes ahead of them
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusCreated, post("low"))
+	}
+	assert.Equal(t, http.StatusCreated, post("high"))
+
+	// At five tasks, Adam takes no more
+	assert.Equal(t, http.StatusAccepted, post("low"))
+
+	adam, err := store.FindAgent(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotPriorities := []service.Priority{}
+	for _, task := range adam.Tasks {
+		gotPriorities = append(gotPriorities, task.Priority)
+	}
+	assert.Equal(t, []service.Priority{service.PriorityHigh, service.PriorityLow, service.PriorityLow, service.PriorityLow, service.PriorityLow}, gotPriorities)
+	pending, err := store.ListPendingTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, pending, 1)
+}
+
+func Test_route_Tasks_New_POST_Schedule(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Adam works around the clock, but today is a holiday; Charlie has no schedule, so is always on shift
+	everyDay := []service.Shift{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		everyDay = append(everyDay, service.Shift{Day: service.Weekday(d), Start: "00:00", End: "24:00"})
+	}
+	holiday := &service.Schedule{Shifts: everyDay, Holidays: []string{time.Now().UTC().Format("2006-01-02")}}
+	adam := func() *service.Agent {
+		return &service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Schedule: holiday, Tasks: []*service.Task{}}
+	}
+	charlie := func() *service.Agent {
+		return &service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{}}
+	}
+
+	tests := []struct {
+		name          string         // Test name
+		store         *service.Store // Initial state of the data store prior to HTTP request
+		postBody      string         // HTTP request body
+		wantStatus    int            // Expected HTTP response code
+		wantAgentName string         // Expected agent assigned the task (for successes)
+	}{
+		{
+			name:          "Off-shift agent is skipped",
+			store:         service.NewStore([]*service.Agent{adam(), charlie()}, nil),
+			postBody:      `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:    http.StatusCreated,
+			wantAgentName: "Charlie",
+		},
+		{
+			name:       "Task waits when every skilled agent is off shift",
+			store:      service.NewStore([]*service.Agent{adam(), charlie()}, nil),
+			postBody:   `{"priority":"high","required_skills":["skill2"]}`,
+			wantStatus: http.StatusAccepted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    tt.store,
+			}
+
+			// Build test request
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantAgentName != "" {
+				var gotTask service.Task
+				err = json.Unmarshal(w.Body.Bytes(), &gotTask) // Unmarshal POST HTTP response body --> Task{}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assert.NotNil(t, gotTask.AssignedAgent) {
+					assert.Equal(t, tt.wantAgentName, gotTask.AssignedAgent.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_New_POST_Preemption(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Only Adam can take the new high task, displacing his low task 1; Charlie could take
+	// task 1 unless he is busy with a high task of his own
+	buildStore := func(policy service.PreemptionPolicy, task1State service.TaskState, charlieBusy bool) *service.Store {
+		charlieTasks := []*service.Task{}
+		if charlieBusy {
+			charlieTasks = append(charlieTasks, &service.Task{ID: 2, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP})
+		}
+		store := service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1, service.Skill2}, Tasks: []*service.Task{
+				&service.Task{ID: 1, Priority: "low", ReqSkills: service.Skills{service.Skill1}, State: task1State},
+			}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, Tasks: charlieTasks},
+		}, nil)
+		err := store.SetPreemptionPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	tests := []struct {
+		name           string         // Test name
+		store          *service.Store // Initial state of the data store prior to HTTP request
+		wantTask1State service.TaskState
+		wantTask1Agent string // Expected holder of task 1 afterwards ("" while waiting)
+		wantEventKind  service.TaskEventKind
+	}{
+		{
+			name:           "Keep leaves the low task queued behind",
+			store:          buildStore(service.PreemptKeep, service.TaskAssigned, false),
+			wantTask1State: service.TaskAssigned,
+			wantTask1Agent: "Adam",
+		},
+		{
+			name:           "Reassign hands the low task to an available agent",
+			store:          buildStore(service.PreemptReassign, service.TaskAssigned, false),
+			wantTask1State: service.TaskAssigned,
+			wantTask1Agent: "Charlie",
+			wantEventKind:  service.TaskEventReassigned,
+		},
+		{
+			name:           "Reassign keeps the low task when no agent is available",
+			store:          buildStore(service.PreemptReassign, service.TaskAssigned, true),
+			wantTask1State: service.TaskAssigned,
+			wantTask1Agent: "Adam",
+		},
+		{
+			name:           "Reassign leaves a started task with its agent",
+			store:          buildStore(service.PreemptReassign, service.TaskInWIP, false),
+			wantTask1State: service.TaskInWIP,
+			wantTask1Agent: "Adam",
+		},
+		{
+			name:           "Return puts the low task back in the pending queue",
+			store:          buildStore(service.PreemptReturn, service.TaskAssigned, true),
+			wantTask1State: service.TaskQueued,
+			wantEventKind:  service.TaskEventReturned,
+		},
+		{
+			name:           "Return offers the low task to an available agent",
+			store:          buildStore(service.PreemptReturn, service.TaskAssigned, false),
+			wantTask1State: service.TaskAssigned,
+			wantTask1Agent: "Charlie",
+			wantEventKind:  service.TaskEventAssigned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare web server components
+			renderer := render.New()
+			dso := &DataSourceOrchestration{
+				Renderer: renderer,
+				Store:    tt.store,
+			}
+
+			// Build test request
+			r, err := http.NewRequest("POST", "/tasks/new", strings.NewReader(`{"priority":"high","required_skills":["skill1","skill2"]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			route_Tasks_New_POST(dso)(w, r, httprouter.Params{})
+
+			// Assertions
+			assert.Equal(t, http.StatusCreated, w.Code)
+			var gotTask service.Task
+			err = json.Unmarshal(w.Body.Bytes(), &gotTask) // Unmarshal POST HTTP response body --> Task{}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.NotNil(t, gotTask.AssignedAgent) {
+				assert.Equal(t, "Adam", gotTask.AssignedAgent.Name)
+			}
+
+			task1, err := tt.store.GetTask(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantTask1State, task1.State)
+			if tt.wantTask1Agent == "" {
+				assert.Nil(t, task1.AssignedAgent)
+			} else if assert.NotNil(t, task1.AssignedAgent) {
+				assert.Equal(t, tt.wantTask1Agent, task1.AssignedAgent.Name)
+			}
+
+			history, _ := tt.store.TaskHistory(1)
+			if tt.wantEventKind == "" {
+				assert.Empty(t, history)
+			} else if assert.NotEmpty(t, history) {
+				assert.Equal(t, tt.wantEventKind, history[len(history)-1].Kind)
+				assert.Equal(t, "Preempted by task "+strconv.Itoa(int(gotTask.ID)), history[0].Reason)
+			}
+		})
+	}
+}
+
+func Test_route_Tasks_DryRun_POST(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+
+	// Every agent but Gina, Hank and Ivan is ruled out for a high skill1 task at a different stage
+	offShift := &service.Schedule{Shifts: []service.Shift{
+		{Day: service.Weekday((time.Now().UTC().Weekday() + 3) % 7), Start: "09:00", End: "10:00"},
+	}}
+	lowTask := func(id uint) []*service.Task {
+		return []*service.Task{&service.Task{ID: id, Priority: "low", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP}}
+	}
+	buildStore := func() *service.Store {
+		return service.NewStore([]*service.Agent{
+			&service.Agent{Name: "Adam", Skills: service.Skills{service.Skill1}, Deactivated: true},
+			&service.Agent{Name: "Betty", Skills: service.Skills{service.Skill2}},
+			&service.Agent{Name: "Charlie", Skills: service.Skills{service.Skill1}, SkillLevels: service.SkillLevels{service.Skill1: 5}, Presence: service.PresenceAway},
+			&service.Agent{Name: "Dana", Skills: service.Skills{service.Skill1}, Capacity: service.Capacity{MaxTasks: 1}, Tasks: lowTask(1)},
+			&service.Agent{Name: "Eve", Skills: service.Skills{service.Skill1}, Tasks: []*service.Task{
+				&service.Task{ID: 2, Priority: "high", ReqSkills: service.Skills{service.Skill1}, State: service.TaskInWIP},
+			}},
+			&service.Agent{Name: "Frank", Skills: service.Skills{service.Skill1}, Schedule: offShift},
+			&service.Agent{Name: "Gina", Skills: service.Skills{service.Skill1}},
+			&service.Agent{Name: "Hank", Skills: service.Skills{service.Skill1}, SkillLevels: service.SkillLevels{service.Skill1: 3}, Tasks: lowTask(3)},
+			&service.Agent{Name: "Ivan", Skills: service.Skills{service.Skill1, service.Skill3}, Tasks: lowTask(4)},
+		}, nil)
+	}
+
+	tests := []struct {
+		name         string                         // Test name
+		path         string                         // HTTP request path
+		postBody     string                         // HTTP request body
+		wantStatus   int                            // Expected HTTP response code
+		wantOutcome  service.DryRunOutcome          // Expected outcome (for successes)
+		wantStages   map[string]service.FilterStage // Expected eliminating stage by agent name; absent agents remain candidates
+		wantRanking  []string                       // Expected remaining candidates, best first
+		wantSelected string                         // Expected agent selected (for assignments)
+	}{
+		{
+			name:        "Each agent is eliminated at its stage and the rest are ranked",
+			path:        "/tasks/dry-run",
+			postBody:    `{"priority":"high","required_skills":["skill1"],"preferred_skills":["skill3"]}`,
+			wantStatus:  http.StatusOK,
+			wantOutcome: service.DryRunAssigned,
+			wantStages: map[string]service.FilterStage{
+				"Adam":    service.StageDeactivated,
+				"Betty":   service.StageSkills,
+				"Charlie": service.StagePresence,
+				"Dana":    service.StageCapacity,
+				"Eve":     service.StagePriority,
+				"Frank":   service.StageShift,
+			},
+			wantRanking:  []string{"Ivan", "Gina", "Hank"},
+			wantSelected: "Ivan",
+		},
+		{
+			name:        "Strategy reorders equally matched agents",
+			path:        "/tasks/dry-run?strategy=least_loaded",
+			postBody:    `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:  http.StatusOK,
+			wantOutcome: service.DryRunAssigned,
+			wantStages: map[string]service.FilterStage{
+				"Adam":    service.StageDeactivated,
+				"Betty":   service.StageSkills,
+				"Charlie": service.StagePresence,
+				"Dana":    service.StageCapacity,
+				"Eve":     service.StagePriority,
+				"Frank":   service.StageShift,
+			},
+			wantRanking:  []string{"Gina", "Ivan", "Hank"},
+			wantSelected: "Gina",
+		},
+		{
+			name:        "Round robin ranks from the agent after the last one it selected",
+			path:        "/tasks/dry-run?strategy=round_robin",
+			postBody:    `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus:  http.StatusOK,
+			wantOutcome: service.DryRunAssigned,
+			wantStages: map[string]service.FilterStage{
+				"Adam":    service.StageDeactivated,
+				"Betty":   service.StageSkills,
+				"Charlie": service.StagePresence,
+				"Dana":    service.StageCapacity,
+				"Eve":     service.StagePriority,
+				"Frank":   service.StageShift,
+			},
+		},
+		{
+			name:        "Task only an absent agent qualifies for would be queued",
+			path:        "/tasks/dry-run",
+			postBody:    `{"priority":"high","required_skills":["skill1"],"min_skill_levels":{"skill1":5}}`,
+			wantStatus:  http.StatusOK,
+			wantOutcome: service.DryRunQueued,
+			wantStages: map[string]service.FilterStage{
+				"Adam":    service.StageDeactivated,
+				"Betty":   service.StageSkills,
+				"Charlie": service.StagePresence,
+				"Dana":    service.StageSkills,
+				"Eve":     service.StageSkills,
+				"Frank":   service.StageSkills,
+				"Gina":    service.StageSkills,
+				"Hank":    service.StageSkills,
+				"Ivan":    service.StageSkills,
+			},
+			wantRanking: []string{},
+		},
+		{
+			name:        "Task no agent has the skills for would be rejected",
+			path:        "/tasks/dry-run",
+			postBody:    `{"priority":"low","required_skills":["skill2","skill3"]}`,
+			wantStatus:  http.StatusOK,
+			wantOutcome: service.DryRunRejected,
+			wantStages: map[string]service.FilterStage{
+				"Adam":    service.StageDeactivated,
+				"Betty":   service.StageSkills,
+				"Charlie": service.StageSkills,
+				"Dana":    service.StageSkills,
+				"Eve":     service.StageSkills,
+				"Frank":   service.StageSkills,
+				"Gina":    service.StageSkills,
+				"Hank":    service.StageSkills,
+				"Ivan":    service.StageSkills,
+			},
+			wantRanking: []string{},
+		},
+		{
+			name:       "Invalid task is rejected",
+			path:       "/tasks/dry-run",
+			postBody:   `{"priority":"whenever","required_skills":["skill1"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown strategy is rejected",
+			path:       "/tasks/dry-run?strategy=nope",
+			postBody:   `{"priority":"high","required_skills":["skill1"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := buildStore()
+			router := newRouter(&DataSourceOrchestration{
+				Renderer: render.New(),
+				Store:    store,
+			})
+
+			// Build test request
+			r, err := http.NewRequest("POST", tt.path, strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Add("Content-Type", "application/json; charset=UTF-8")
+
+			// Build response recorder
+			w := httptest.NewRecorder()
+
+			// Execute test request
+			router.ServeHTTP(w, r)
+
+			// Assertions
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var run service.AssignmentDryRun
+			err = json.Unmarshal(w.Body.Bytes(), &run)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantOutcome, run.Outcome)
+			if assert.Equal(t, 9, len(run.Agents)) {
+				for _, ev := range run.Agents {
+					assert.Equal(t, tt.wantStages[ev.Name], ev.EliminatedBy, ev.Name)
+					assert.Equal(t, ev.EliminatedBy == "", ev.Rank > 0, ev.Name)
+				}
+			}
+			gotRanking := []string{}
+			for _, ev := range run.Ranking {
+				gotRanking = append(gotRanking, ev.Name)
+			}
+			if tt.wantRanking != nil {
+				assert.Equal(t, tt.wantRanking, gotRanking)
+			}
+			if tt.wantSelected != "" && assert.NotEmpty(t, run.Ranking) {
+				assert.Equal(t, tt.wantSelected, run.Ranking[0].Name)
+				assert.Equal(t, run.Ranking[0].AgentID, run.SelectedAgentID)
+			}
+
+			// Nothing was created or assigned
+			pending, _ := store.ListPendingTasks()
+			assert.Empty(t, pending)
+			assert.Equal(t, uint(5), store.NextTaskID())
+
+			// Creating the task for real picks the same agent
+			if run.Outcome != service.DryRunAssigned {
+				return
+			}
+			r, err = http.NewRequest("POST", strings.Replace(tt.path, "dry-run", "new", 1), strings.NewReader(tt.postBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if assert.Equal(t, http.StatusCreated, w.Code) {
+				var gotTask service.Task
+				err = json.Unmarshal(w.Body.Bytes(), &gotTask)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assert.NotNil(t, gotTask.AssignedAgent) {
+					assert.Equal(t, run.SelectedAgentID, gotTask.AssignedAgent.ID)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/astockwell/ffn/pkg/service"
 	"github.com/julienschmidt/httprouter"
@@ -11,36 +20,260 @@ import (
 )
 
 func main() {
+	storeType := flag.String("store", "memory", "Data store backend: memory, file, bolt")
+	dataDir := flag.String("data-dir", "data", "Directory for persistent data store files")
+	strategyName := flag.String("strategy", "standard", "Default agent assignment strategy: "+strings.Join(service.AssignmentStrategyNames(), ", "))
+	priorityList := flag.String("priorities", "high,low", "Comma-separated task priority levels, most urgent first")
+	preemption := flag.String("preemption", "keep", "What happens to an agent's unstarted tasks when a higher-priority task is put in front of them: keep, reassign, return")
+	avoidShiftOverrun := flag.Bool("avoid-shift-overrun", false, "Keep tasks with an estimated duration from agents whose shift ends before they could finish them")
+	requeueInterval := flag.Duration("requeue-interval", time.Minute, "How often waiting tasks are retried, e.g. for agents starting a shift")
+	slaTargets := flag.String("sla", "", "Comma-separated SLA targets as priority:time-to-assign:time-to-complete, e.g. high:5m:1h,low::8h")
+	slaAtRisk := flag.Float64("sla-at-risk", service.DefaultSLAAtRisk, "Fraction of the time allowed by an SLA target after which a task is at risk")
+	slaCheckInterval := flag.Duration("sla-check-interval", 30*time.Second, "How often open tasks are checked against their SLA targets")
+	escalationRulesPath := flag.String("escalation-rules", "", "JSON file of rules escalating tasks that spend too long in a state")
+	escalationInterval := flag.Duration("escalation-interval", time.Minute, "How often the escalation rules are applied")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "How often the file store compacts its journal into a snapshot")
+	flag.Parse()
+
 	// Setup Logging
 	log.SetFormatter(&prefixed.TextFormatter{FullTimestamp: true, TimestampFormat: "2006/01/02 15:04:05", ForceFormatting: true})
 	log.SetLevel(log.TraceLevel)
 
-	// Setup data store
-	store := &service.Store{}
+	// Configure priority levels; this must precede restoring any persisted (ranked) pending tasks
+	priorityLevels, err := service.ParsePriorityLevels(*priorityList)
+	if err != nil {
+		log.Fatal("Error parsing priority levels:", err)
+	}
+	err = service.ConfigurePriorities(priorityLevels)
+	if err != nil {
+		log.Fatal("Error configuring priority levels:", err)
+	}
+
+	// Setup data store; any service.Repository implementation may be used here. Persistent
+	// stores are closed (e.g. with a final snapshot) on shutdown.
+	var store service.Repository
+	var closeStore func() error
+	switch *storeType {
+	case "memory":
+		store = &service.Store{}
+	case "file":
+		fileStore, err := service.OpenFileStore(*dataDir, *snapshotInterval)
+		if err != nil {
+			log.Fatal("Error opening file store:", err)
+		}
+		closeStore = fileStore.Close
+		store = fileStore
+	case "bolt":
+		err := os.MkdirAll(*dataDir, 0755)
+		if err != nil {
+			log.Fatal("Error creating data directory:", err)
+		}
+		boltStore, err := service.OpenBoltStore(filepath.Join(*dataDir, "agenttaskapi.db"))
+		if err != nil {
+			log.Fatal("Error opening bolt store:", err)
+		}
+		closeStore = boltStore.Close
+		store = boltStore
+	default:
+		log.Fatalf("Unknown store type: %s", *storeType)
+	}
+
+	// Configure assignment strategy
+	strategy, err := service.LookupAssignmentStrategy(*strategyName)
+	if err != nil {
+		log.Fatal("Error selecting assignment strategy:", err)
+	}
+	store.SetAssignmentStrategy(strategy)
+	store.SetAvoidShiftOverrun(*avoidShiftOverrun)
+	err = store.SetPreemptionPolicy(service.PreemptionPolicy(*preemption))
+	if err != nil {
+		log.Fatal("Error configuring preemption policy:", err)
+	}
 
-	// Seed data store
-	log.Tracef("Building Seed Agents...")
-	agents := service.BuildSeedAgents()
-	log.Tracef("Persisting Seed Agents...")
-	err := store.AddAgents(agents)
+	// Configure SLA targets
+	targets, err := service.ParseSLATargets(*slaTargets)
 	if err != nil {
-		log.Fatal("Error provisioning seed Agents:", err)
+		log.Fatal("Error parsing SLA targets:", err)
+	}
+	err = store.SetSLAPolicy(service.SLAPolicy{Targets: targets, AtRisk: *slaAtRisk})
+	if err != nil {
+		log.Fatal("Error configuring SLA targets:", err)
+	}
+
+	// Seed data store, unless it was restored from disk with skills/agents already in it
+	existingSkills, err := store.ListSkills()
+	if err != nil {
+		log.Fatal("Error listing existing Skills:", err)
+	}
+	if len(existingSkills) == 0 {
+		log.Tracef("Persisting Seed Skills...")
+		for _, sd := range service.BuildSeedSkills() {
+			err = store.AddSkill(*sd)
+			if err != nil {
+				log.Fatal("Error provisioning seed Skills:", err)
+			}
+		}
+	}
+	existingAgents, err := store.ListAgents()
+	if err != nil {
+		log.Fatal("Error listing existing Agents:", err)
+	}
+	if len(existingAgents) == 0 {
+		log.Tracef("Building Seed Agents...")
+		agents := service.BuildSeedAgents()
+		log.Tracef("Persisting Seed Agents...")
+		err = store.AddAgents(agents)
+		if err != nil {
+			log.Fatal("Error provisioning seed Agents:", err)
+		}
+	}
+
+	stopRequeue := every(*requeueInterval, func(time.Time) {
+		store.AssignPendingTasks()
+	})
+
+	// Configure escalation rules
+	if *escalationRulesPath != "" {
+		data, err := ioutil.ReadFile(*escalationRulesPath)
+		if err != nil {
+			log.Fatal("Error reading escalation rules:", err)
+		}
+		var rules []service.EscalationRule
+		err = json.Unmarshal(data, &rules)
+		if err != nil {
+			log.Fatal("Error parsing escalation rules:", err)
+		}
+		err = store.SetEscalationRules(rules)
+		if err != nil {
+			log.Fatal("Error configuring escalation rules:", err)
+		}
+	}
+
+	stopEscalation := every(*escalationInterval, func(now time.Time) {
+		err := store.Escalate(now)
+		if err != nil {
+			log.Errorf("Store.Escalate(): %v", err)
+		}
+	})
+	stopSLAChecks := every(*slaCheckInterval, func(now time.Time) {
+		err := store.CheckSLAs(now)
+		if err != nil {
+			log.Errorf("Store.CheckSLAs(): %v", err)
+		}
+	})
+
+	// The periodic jobs are stopped first, so none writes to the store as it closes
+	if closeStore != nil {
+		closeOnSignal(func() error {
+			stopRequeue()
+			stopEscalation()
+			stopSLAChecks()
+			return closeStore()
+		})
 	}
 
 	// Prepare web server components
 	renderer := render.New()
-	router := httprouter.New()
 	dso := &DataSourceOrchestration{
 		Renderer: renderer,
 		Store:    store,
 	}
-
-	// Web server routes
-	router.GET("/", mwLogger(route_Index(dso)))
-	router.POST("/tasks/new", mwLogger(route_Tasks_New_POST(dso)))
-	router.POST("/tasks/complete", mwLogger(route_Tasks_Update_Complete_POST(dso)))
+	router := newRouter(dso)
 
 	// Serve HTTP
 	log.Infof("HTTP Web server (no TLS) listening on %s", ":8080")
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
+
+// newRouter registers the web server routes
+func newRouter(dso *DataSourceOrchestration) *httprouter.Router {
+	router := httprouter.New()
+
+	router.GET("/", mwLogger(route_Index(dso)))
+	router.GET("/priorities", mwLogger(route_Priorities(dso)))
+	router.GET("/sla", mwLogger(route_SLA(dso)))
+	router.GET("/escalations", mwLogger(route_Escalations(dso)))
+
+	// /tasks/new, /tasks/dry-run and /tasks/complete share their segment with /tasks/:id
+	router.POST("/tasks/:id", mwStaticParam("id", map[string]httprouter.Handle{
+		"new":      mwLogger(route_Tasks_New_POST(dso)),
+		"dry-run":  mwLogger(route_Tasks_DryRun_POST(dso)),
+		"complete": mwLogger(route_Tasks_Update_Complete_POST(dso)),
+	}, nil))
+	for action := range taskTransitions {
+		router.POST("/tasks/:id/"+action, mwLogger(route_Tasks_Transition_POST(dso, action)))
+	}
+	router.POST("/tasks/:id/complete", mwLogger(route_Tasks_Complete_POST(dso)))
+	router.POST("/tasks/:id/reassign", mwLogger(route_Tasks_Reassign_POST(dso)))
+	router.GET("/tasks/:id", mwStaticParam("id", map[string]httprouter.Handle{
+		"completed": mwLogger(route_Tasks_Completed(dso)),
+		"sla":       mwLogger(route_Tasks_SLA(dso)),
+	}, mwLogger(route_Task(dso))))
+	router.GET("/tasks/:id/history", mwLogger(route_Tasks_History(dso)))
+
+	router.GET("/agents", mwLogger(route_Index(dso)))
+	router.POST("/agents", mwLogger(route_Agents_New_POST(dso)))
+	router.GET("/agents/:id", mwLogger(route_Agent(dso)))
+	router.GET("/agents/:id/tasks", mwLogger(route_Agent_Tasks(dso)))
+	router.PUT("/agents/:id/skills", mwLogger(route_Agent_Skills_PUT(dso)))
+	router.PUT("/agents/:id/capacity", mwLogger(route_Agent_Capacity_PUT(dso)))
+	router.PUT("/agents/:id/schedule", mwLogger(route_Agent_Schedule_PUT(dso)))
+	router.PUT("/agents/:id/presence", mwLogger(route_Agent_Presence_PUT(dso)))
+	router.GET("/agents/:id/presence", mwLogger(route_Agent_Presence(dso)))
+	router.POST("/agents/:id/deactivate", mwLogger(route_Agent_Deactivate_POST(dso)))
+	router.POST("/agents/:id/activate", mwLogger(route_Agent_Activate_POST(dso)))
+	router.DELETE("/agents/:id", mwLogger(route_Agent_DELETE(dso)))
+
+	router.GET("/skills", mwLogger(route_Skills(dso)))
+	router.POST("/skills", mwLogger(route_Skills_New_POST(dso)))
+	router.GET("/skills/:name", mwLogger(route_Skill(dso)))
+	router.PUT("/skills/:name", mwLogger(route_Skill_PUT(dso)))
+	router.DELETE("/skills/:name", mwLogger(route_Skill_DELETE(dso)))
+
+	return router
+}
+
+// every runs fn in the background once per interval, with the time of each tick, until
+// stopped; stop waits for any run in progress to finish, and an interval of zero or less
+// never runs it. Shifts starting, tasks going stale and SLA targets running out happen
+// without any request to announce them, so the store is prompted to catch up on a timer.
+func every(interval time.Duration, fn func(now time.Time)) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case now := <-ticker.C:
+				fn(now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
+
+// closeOnSignal runs fn (e.g. a final snapshot) before exiting on SIGINT/SIGTERM
+func closeOnSignal(fn func() error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Infof("Received %v, shutting down", sig)
+		err := fn()
+		if err != nil {
+			log.Errorf("Error during shutdown: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
@@ -23,3 +23,22 @@ func httpRedirect(w http.ResponseWriter, r *http.Request, url string, code int)
 	log.Infof("%s Redirected to %s with %d", r.RemoteAddr, url, code)
 	http.Redirect(w, r, url, code)
 }
+
+// mwStaticParam lets a static path segment share its position with a wildcard,
+// which httprouter does not allow (e.g. /tasks/new alongside /tasks/:id/start).
+// The route is registered with the wildcard, and requests whose parameter
+// matches one of the static names are served by that name's handler; anything
+// else is served by fallback, or is not found if fallback is nil.
+func mwStaticParam(param string, statics map[string]httprouter.Handle, fallback httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, rp httprouter.Params) {
+		if fn, ok := statics[rp.ByName(param)]; ok {
+			fn(w, r, rp)
+			return
+		}
+		if fallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		fallback(w, r, rp)
+	}
+}
@@ -9,5 +9,5 @@ import (
 // to handlers to prevent having constant propogating changes.
 type DataSourceOrchestration struct {
 	Renderer *render.Render
-	Store    *service.Store
+	Store    service.Repository
 }
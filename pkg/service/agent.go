@@ -1,14 +1,35 @@
 package service
 
-import "sort"
-
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
 
 type Agent struct {
 	ID     uint   `json:"id"`
 	Name   string `json:"name"`
 	Skills Skills `json:"skills"`
 
+	// SkillLevels is the agent's proficiency in each of Skills; MinSkillLevel if not given
+	SkillLevels SkillLevels `json:"skill_levels,omitempty"`
+
+	// Deactivated agents keep their identity and skills, but are never assigned tasks
+	Deactivated bool `json:"deactivated"`
+
+	// Presence is whether the agent is online; only online agents are assigned tasks
+	Presence Presence `json:"presence"`
+
+	// Capacity limits how many tasks the agent may hold at once
+	Capacity
+
+	// Schedule is the agent's shift calendar; agents without one are always on shift
+	Schedule *Schedule `json:"schedule,omitempty"`
+
+	// IdleSince is when the agent's queue last became empty; zero if it never held a task
+	IdleSince time.Time `json:"idle_since"`
+
 	Tasks []*Task `json:"tasks,omitempty"`
 }
 
@@ -34,18 +55,27 @@ func BuildSeedAgents() []*Agent {
 	}
 }
 
-// FilterForAvailableByPriority returns a slice of agents availabile for a task of the given priority
-func (as *Agents) FilterForAvailableByPriority(targetPriority Priority) (availableAgents Agents, atLeastOneAgentAvailable bool) {
-	availableAgents = []Agent{}
+// FilterForBestMatch returns the agents who best match the task, keeping their existing order:
+// those with the most of its preferred skills and then, among those, the highest match score
+func (as *Agents) FilterForBestMatch(t *Task) Agents {
+	bestAgents := []Agent{}
+	bestPreferred, bestScore := 0, 0
 
 	for _, a := range *as {
-		if !a.AvailableForAssignment(targetPriority) {
-			continue
+		preferred, score := a.PreferredSkillCount(t), a.MatchScore(t)
+		if len(bestAgents) > 0 {
+			if preferred < bestPreferred || (preferred == bestPreferred && score < bestScore) {
+				continue
+			}
+			if preferred > bestPreferred || score > bestScore {
+				bestAgents = bestAgents[:0]
+			}
 		}
-		availableAgents = append(availableAgents, a)
+		bestPreferred, bestScore = preferred, score
+		bestAgents = append(bestAgents, a)
 	}
 
-	return availableAgents, (len(availableAgents) > 0)
+	return bestAgents
 }
 
 // FilterForNoTasksAssigned returns a slice of agents with no tasks assigned
@@ -62,9 +92,10 @@ func (as *Agents) FilterForNoTasksAssigned() (idleAgents Agents, atLeastOneAgent
 	return idleAgents, (len(idleAgents) > 0)
 }
 
-// SortByTaskCount sorts a slice of agents by the # of assigned tasks they have, lowest-to-highest
+// SortByTaskCount sorts a slice of agents by the # of assigned tasks they have, lowest-to-highest;
+// agents with equal counts keep their existing order
 func (as *Agents) SortByTaskCount() {
-	sort.Slice(*as, func(i, j int) bool {
+	sort.SliceStable(*as, func(i, j int) bool {
 		return len((*as)[i].Tasks) < len((*as)[j].Tasks)
 	})
 }
@@ -81,46 +112,149 @@ func (as *Agents) SortByTaskStartTime() error {
 	return nil
 }
 
-// PluckRandomAgent returns a random agent from the receiver
-// TODO: NOT YET IMPLEMENTED, SELECTS 1st AGENT DETERMINISTICALLY
+// SortByIdleSince sorts a slice of agents by how long they have been idle, longest first
+func (as *Agents) SortByIdleSince() {
+	sort.SliceStable(*as, func(i, j int) bool {
+		return (*as)[i].IdleSince.Before((*as)[j].IdleSince)
+	})
+}
+
+// PluckRandomAgent returns a random agent from the receiver, chosen uniformly via the source set by SetRandomSource
 func (as *Agents) PluckRandomAgent() (Agent, error) {
 	if len(*as) < 1 {
 		return Agent{}, fmt.Errorf("No agents to pick from")
 	}
-	return (*as)[0], nil
+	return (*as)[randomIntn(len(*as))], nil
 }
 
-func (a *Agent) HasSkills(ss Skills) bool {
-	for _, skill := range ss {
-		if !a.Skills.Includes(skill) {
-			return false
+func (a *Agent) IsValid() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return fmt.Errorf("Agent name is required")
+	}
+	for _, skill := range a.Skills {
+		if err := skill.IsValid(); err != nil {
+			return err
 		}
 	}
-	return true
+	if err := a.SkillLevels.IsValid(a.Skills); err != nil {
+		return err
+	}
+	if a.Schedule != nil {
+		if err := a.Schedule.IsValid(); err != nil {
+			return err
+		}
+	}
+	return a.Capacity.IsValid()
 }
 
+// MatchScore rates how closely a qualified agent's proficiency matches what the task
+// requires: 0 for an exact match, less the more levels they are overqualified by
+func (a *Agent) MatchScore(t *Task) int {
+	score := 0
+	for _, skill := range t.ReqSkills {
+		score -= a.SkillLevels.level(skill) - t.MinSkillLevels.level(skill)
+	}
+	return score
+}
+
+// PreferredSkillCount returns how many of the task's preferred skills the agent has
+func (a *Agent) PreferredSkillCount(t *Task) int {
+	n := 0
+	for _, skill := range t.PrefSkills {
+		if a.Skills.Includes(skill) {
+			n++
+		}
+	}
+	return n
+}
+
+// AvailableForAssignment reports whether the agent may take a task of the given priority;
+// an agent is blocked while not online, once at capacity, or, without a capacity limit,
+// by any task they hold of equal or higher rank
 func (a *Agent) AvailableForAssignment(p Priority) bool {
+	if a.Presence != PresenceOnline {
+		return false
+	}
+	if !a.Capacity.allows(a.Tasks, p) {
+		return false
+	}
+	return a.blockingTask(p) == nil
+}
+
+// blockingTask returns a task that keeps the agent from taking another of priority p, or nil.
+// Agents with a capacity limit for p stack tasks up to it, whatever their priority; others
+// take one task per rank, so are blocked by any task they hold of equal or higher rank.
+func (a *Agent) blockingTask(p Priority) *Task {
+	if a.Capacity.limit(p) > 0 {
+		return nil
+	}
+	return a.heldTaskAtOrAbove(p)
+}
+
+// heldTaskAtOrAbove returns the first task the agent holds of equal or higher rank than the given priority, or nil
+func (a *Agent) heldTaskAtOrAbove(p Priority) *Task {
+	rank := p.Rank()
 	for _, t := range a.Tasks {
-		switch t.Priority {
-		case PriorityHigh:
-			// This is the highest priority, an agent with a task
-			// of this priority is unavailable in all cases.
-			return false
-		case PriorityLow:
-			if p == PriorityLow {
-				return false
-			}
+		if t.Priority.Rank() >= rank {
+			return t
+		}
+	}
+	return nil
+}
+
+// rivalTask returns a task other than the given one that the agent holds at the same rank as
+// priority p, or nil; agents with a capacity limit for p may hold several, so have no rival
+func (a *Agent) rivalTask(p Priority, taskID uint) *Task {
+	if a.Capacity.limit(p) > 0 {
+		return nil
+	}
+	rank := p.Rank()
+	for _, t := range a.Tasks {
+		if t.ID != taskID && t.Priority.Rank() == rank {
+			return t
 		}
 	}
-	return true
+	return nil
+}
+
+// moveAheadOfLessUrgent returns the queue with the task moved in front of the first less
+// urgent task, after those as or more urgent; the rest keep their order
+func moveAheadOfLessUrgent(tasks []*Task, t *Task) []*Task {
+	queue := make([]*Task, 0, len(tasks))
+	for _, held := range tasks {
+		if held != t {
+			queue = append(queue, held)
+		}
+	}
+	i := 0
+	for i < len(queue) && queue[i].Priority.Rank() >= t.Priority.Rank() {
+		i++
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = t
+	return queue
+}
+
+// markIdleIfEmpty records the time the agent's queue became empty
+func (a *Agent) markIdleIfEmpty(at time.Time) {
+	if len(a.Tasks) == 0 {
+		a.IdleSince = at
+	}
 }
 
 func (a *Agent) Clone() Agent {
 	return Agent{
-		ID:     a.ID,
-		Name:   a.Name,
-		Skills: a.Skills,
-		Tasks:  a.Tasks,
+		ID:          a.ID,
+		Name:        a.Name,
+		Skills:      a.Skills,
+		SkillLevels: a.SkillLevels,
+		Deactivated: a.Deactivated,
+		Presence:    a.Presence,
+		Capacity:    a.Capacity,
+		Schedule:    a.Schedule,
+		IdleSince:   a.IdleSince,
+		Tasks:       a.Tasks,
 	}
 }
 
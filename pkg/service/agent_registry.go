@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAgentNotFound         = fmt.Errorf("Agent not found")
+	ErrAgentHasTasks         = fmt.Errorf("Agent has in-flight tasks")
+	ErrAgentSkillInUse       = fmt.Errorf("Agent holds a task requiring a skill being removed")
+	ErrAgentDeactivated      = fmt.Errorf("Agent is deactivated")
+	ErrInvalidInFlightPolicy = fmt.Errorf("Invalid in-flight task policy")
+)
+
+// InFlightPolicy decides what happens to an agent's in-flight tasks when the
+// agent is deactivated or deleted
+type InFlightPolicy string
+
+const (
+	// InFlightBlock refuses the change while the agent holds any task
+	InFlightBlock InFlightPolicy = "block"
+	// InFlightReturn returns the agent's tasks to the pending queue, to be reassigned
+	InFlightReturn InFlightPolicy = "return"
+)
+
+// ParseInFlightPolicy returns the named policy; an empty name is InFlightBlock
+func ParseInFlightPolicy(name string) (InFlightPolicy, error) {
+	switch InFlightPolicy(name) {
+	case "", InFlightBlock:
+		return InFlightBlock, nil
+	case InFlightReturn:
+		return InFlightReturn, nil
+	}
+	return "", errors.Wrapf(ErrInvalidInFlightPolicy, "%q (expected %q or %q)", name, InFlightBlock, InFlightReturn)
+}
+
+// UpdateAgentSkills replaces an agent's skills and proficiency levels. Skills
+// required by a task the agent currently holds cannot be removed, nor lowered
+// below the level it requires.
+func (s *Store) UpdateAgentSkills(agentID uint, ss Skills, levels SkillLevels) error {
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+	err := s.validateSkills(ss)
+	if err == nil {
+		err = levels.IsValid(ss)
+	}
+	if err != nil {
+		s.Unlock()
+		return err
+	}
+	for _, t := range agent.Tasks {
+		for _, skill := range t.ReqSkills {
+			if !ss.Includes(skill) {
+				s.Unlock()
+				return errors.Wrapf(ErrAgentSkillInUse, "Task %d requires %v", t.ID, skill)
+			}
+			if levels.level(skill) < t.MinSkillLevels.level(skill) {
+				s.Unlock()
+				return errors.Wrapf(ErrAgentSkillInUse, "Task %d requires %v at level %d", t.ID, skill, t.MinSkillLevels.level(skill))
+			}
+		}
+	}
+
+	updated := agent.Clone()
+	updated.Skills = ss
+	updated.SkillLevels = levels
+	updated.Tasks = nil
+	err = s.commit(&JournalEntry{Op: OpSetAgentSkills, AgentID: agentID, Agent: &updated})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// New skills may qualify the agent for waiting tasks
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// SetAgentCapacity replaces an agent's capacity limits. Lowering them below what
+// the agent currently holds takes nothing away, but blocks further assignments.
+func (s *Store) SetAgentCapacity(agentID uint, c Capacity) error {
+	err := c.IsValid()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+
+	updated := agent.Clone()
+	updated.Capacity = c
+	updated.Tasks = nil
+	err = s.commit(&JournalEntry{Op: OpSetAgentCapacity, AgentID: agentID, Agent: &updated})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Raised limits may free the agent for waiting tasks
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// DeactivateAgent stops an agent from being assigned tasks, handling any
+// tasks they hold according to the policy
+func (s *Store) DeactivateAgent(agentID uint, policy InFlightPolicy) error {
+	return s.retireAgent(OpDeactivateAgent, agentID, policy)
+}
+
+// DeleteAgent removes an agent, handling any tasks they hold according to the policy
+func (s *Store) DeleteAgent(agentID uint, policy InFlightPolicy) error {
+	return s.retireAgent(OpDeleteAgent, agentID, policy)
+}
+
+// retireAgent deactivates or deletes an agent; returned tasks are offered to the remaining agents
+func (s *Store) retireAgent(op JournalOp, agentID uint, policy InFlightPolicy) error {
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+	if len(agent.Tasks) > 0 {
+		switch policy {
+		case InFlightBlock:
+			s.Unlock()
+			return errors.Wrapf(ErrAgentHasTasks, "%d task(s) must be completed first", len(agent.Tasks))
+		case InFlightReturn:
+		default:
+			s.Unlock()
+			return ErrInvalidInFlightPolicy
+		}
+	}
+
+	reason := "Agent deactivated"
+	if op == OpDeleteAgent {
+		reason = "Agent deleted"
+	}
+	events := []TaskEvent{}
+	for _, t := range agent.Tasks {
+		events = append(events, newTaskEvent(TaskEventReturned, t, TaskQueued, 0, ChangeInfo{Actor: ActorSystem, Reason: reason}))
+	}
+
+	err := s.commit(&JournalEntry{Op: op, AgentID: agentID, Events: events})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// ActivateAgent makes a deactivated agent eligible for assignment again
+func (s *Store) ActivateAgent(agentID uint) error {
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+	if !agent.Deactivated {
+		s.Unlock()
+		return nil
+	}
+
+	err := s.commit(&JournalEntry{Op: OpActivateAgent, AgentID: agentID})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// returnAgentTasks moves every task an agent holds back to the pending queue,
+// keeping their original arrival time; callers must hold the lock
+func (s *Store) returnAgentTasks(agent *Agent, at time.Time) {
+	if len(agent.Tasks) == 0 {
+		return
+	}
+	for _, t := range agent.Tasks {
+		t.AssignmentTime = time.Time{}
+		t.State = TaskQueued
+		s.insertPendingTask(t)
+	}
+	agent.Tasks = []*Task{}
+	agent.markIdleIfEmpty(at)
+}
+
+// removeAgent snips an agent from the store, retiring its ID; callers must hold the lock
+func (s *Store) removeAgent(agentID uint) {
+	for i, a := range s.agents {
+		if a.ID == agentID {
+			s.agents = append(s.agents[:i], s.agents[i+1:]...)
+			break
+		}
+	}
+	if agentID > s.retiredAgentID {
+		s.retiredAgentID = agentID
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Agents_PluckRandomAgent(t *testing.T) {
+	agents := Agents{Agent{ID: 1}, Agent{ID: 2}, Agent{ID: 3}}
+
+	pickMany := func() []uint {
+		picks := make([]uint, 0, 300)
+		for i := 0; i < 300; i++ {
+			a, err := agents.PluckRandomAgent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			picks = append(picks, a.ID)
+		}
+		return picks
+	}
+
+	// Every agent gets picked
+	defer SetRandomSource(SetRandomSource(rand.NewSource(42)))
+	picks := pickMany()
+	counts := map[uint]int{}
+	for _, id := range picks {
+		counts[id]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for id, n := range counts {
+		assert.True(t, n > 50, "agent %d picked only %d/300 times", id, n)
+	}
+
+	// The same seed reproduces the same picks
+	SetRandomSource(rand.NewSource(42))
+	assert.Equal(t, picks, pickMany())
+
+	// No agents to pick from
+	_, err := (&Agents{}).PluckRandomAgent()
+	assert.Error(t, err)
+}
+
+func Test_Agents_FilterForBestMatch(t *testing.T) {
+	task := &Task{ReqSkills: Skills{Skill1, Skill2}, MinSkillLevels: SkillLevels{Skill1: 3}}
+	store := NewStore([]*Agent{
+		&Agent{Skills: Skills{Skill1, Skill2}, SkillLevels: SkillLevels{Skill1: 5, Skill2: 1}}, // Overqualified by 2
+		&Agent{Skills: Skills{Skill1, Skill2}, SkillLevels: SkillLevels{Skill1: 2}},            // Underqualified
+		&Agent{Skills: Skills{Skill1, Skill2}, SkillLevels: SkillLevels{Skill1: 3, Skill2: 2}}, // Overqualified by 1
+		&Agent{Skills: Skills{Skill1}, SkillLevels: SkillLevels{Skill1: 3}},                    // Lacks skill2
+		&Agent{Skills: Skills{Skill2, Skill1}, SkillLevels: SkillLevels{Skill1: 4}},            // Overqualified by 1
+	}, nil)
+
+	evals, qualified, err := store.evaluateAgents(task, time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, StageSkills, evals[1].EliminatedBy)
+	assert.Equal(t, StageSkills, evals[3].EliminatedBy)
+	ids := []uint{}
+	for _, a := range qualified {
+		ids = append(ids, a.ID)
+	}
+	assert.Equal(t, []uint{1, 3, 5}, ids)
+
+	assert.Equal(t, -2, qualified[0].MatchScore(task))
+	best := qualified.FilterForBestMatch(task)
+	ids = []uint{}
+	for _, a := range best {
+		ids = append(ids, a.ID)
+	}
+	assert.Equal(t, []uint{3, 5}, ids)
+}
+
+func Test_Agents_FilterForBestMatch_PreferredSkills(t *testing.T) {
+	task := &Task{ReqSkills: Skills{Skill1}, PrefSkills: Skills{Skill2, Skill3}}
+	agents := Agents{
+		Agent{ID: 1, Skills: Skills{Skill1}},                                                      // No preferred skills
+		Agent{ID: 2, Skills: Skills{Skill1, Skill2}, SkillLevels: SkillLevels{Skill1: 4}},         // One, but overqualified
+		Agent{ID: 3, Skills: Skills{Skill1, Skill3}},                                              // One
+		Agent{ID: 4, Skills: Skills{Skill1, Skill2, Skill3}, SkillLevels: SkillLevels{Skill1: 5}}, // Both, though most overqualified
+	}
+
+	assert.Equal(t, 2, agents[3].PreferredSkillCount(task))
+	assert.Equal(t, Agents{agents[3]}, agents.FilterForBestMatch(task))
+	rest := agents[:3]
+	assert.Equal(t, Agents{agents[2]}, rest.FilterForBestMatch(task))
+}
+
+func Test_Store_EvaluateAgents_Shift(t *testing.T) {
+	agents := []*Agent{
+		&Agent{Schedule: &Schedule{TimeZone: "America/New_York", Shifts: []Shift{
+			{Day: Weekday(time.Monday), Start: "09:00", End: "17:00"},
+		}}},
+		&Agent{Schedule: &Schedule{Shifts: []Shift{ // Overnight
+			{Day: Weekday(time.Monday), Start: "22:00", End: "06:00"},
+		}}},
+		&Agent{Schedule: &Schedule{Shifts: []Shift{ // Back-to-back
+			{Day: Weekday(time.Monday), Start: "00:00", End: "24:00"},
+			{Day: Weekday(time.Tuesday), Start: "00:00", End: "12:00"},
+		}}},
+		&Agent{Schedule: &Schedule{Shifts: []Shift{
+			{Day: Weekday(time.Monday), Start: "00:00", End: "24:00"},
+		}, Holidays: []string{"2026-10-12"}}},
+		&Agent{}, // No schedule
+	}
+	for _, a := range agents {
+		if a.Schedule != nil {
+			assert.NoError(t, a.Schedule.IsValid())
+		}
+	}
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		avoidOverrun bool
+		estimate     int
+		wantIDs      []uint
+	}{
+		{
+			name:    "Monday morning in New York",
+			at:      time.Date(2026, 10, 12, 15, 0, 0, 0, time.UTC),
+			wantIDs: []uint{1, 3, 5},
+		},
+		{
+			name:    "Overnight shift runs into Tuesday",
+			at:      time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC),
+			wantIDs: []uint{2, 3, 5},
+		},
+		{
+			name:         "Long task avoids a shift ending soon",
+			at:           time.Date(2026, 10, 12, 20, 0, 0, 0, time.UTC),
+			avoidOverrun: true,
+			estimate:     2 * 60 * 60,
+			wantIDs:      []uint{3, 5},
+		},
+		{
+			name:         "Short task fits before the shift ends",
+			at:           time.Date(2026, 10, 12, 20, 0, 0, 0, time.UTC),
+			avoidOverrun: true,
+			estimate:     30 * 60,
+			wantIDs:      []uint{1, 3, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(agents, nil)
+			store.SetAvoidShiftOverrun(tt.avoidOverrun)
+			evals, onShift, err := store.evaluateAgents(&Task{EstimatedSeconds: tt.estimate}, tt.at, 0)
+			assert.NoError(t, err)
+			ids := []uint{}
+			for _, a := range onShift {
+				ids = append(ids, a.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			for _, e := range evals {
+				if e.EliminatedBy != "" {
+					assert.Equal(t, StageShift, e.EliminatedBy, "agent %d", e.AgentID)
+				}
+			}
+		})
+	}
+
+	// Shift times must be HH:MM
+	assert.Error(t, (&Schedule{Shifts: []Shift{{Day: Weekday(time.Monday), Start: "9am", End: "17:00"}}}).IsValid())
+	assert.Error(t, (&Schedule{TimeZone: "Mars/Olympus_Mons", Shifts: []Shift{{Day: Weekday(time.Monday), Start: "09:00", End: "17:00"}}}).IsValid())
+}
@@ -0,0 +1,808 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBucketSkills    = []byte("skills")
+	boltBucketAgents    = []byte("agents")
+	boltBucketTasks     = []byte("tasks")
+	boltBucketPending   = []byte("pending_tasks")
+	boltBucketCompleted = []byte("completed_tasks")
+	boltBucketCancelled = []byte("cancelled_tasks")
+	boltBucketIdxAgent  = []byte("idx_tasks_by_agent")
+	boltBucketIdxState  = []byte("idx_tasks_by_state")
+	boltBucketHistory   = []byte("task_history")
+	boltBucketPresence  = []byte("presence_log")
+	boltBucketMeta      = []byte("meta")
+
+	boltKeyRetiredAgentID = []byte("retired_agent_id")
+	boltKeyRetiredTaskID  = []byte("retired_task_id")
+)
+
+// BoltStore (bolt) is a Store persisted to an embedded, single-file bbolt
+// database. Skills, agents, active, pending, completed and cancelled tasks are kept in separate
+// buckets, with secondary indexes of task IDs by agent and by state. Every
+// mutation is written through to the database in its own transaction before
+// being applied in memory; on open, the in-memory working set is rebuilt
+// from the buckets.
+type BoltStore struct {
+	*Store
+	db *bolt.DB
+}
+
+// boltAgentRecord is an agent as stored in the agents bucket; its task queue
+// is stored as an ordered list of IDs into the tasks bucket
+type boltAgentRecord struct {
+	Agent
+	TaskIDs []uint `json:"task_ids"`
+}
+
+// boltTaskRecord is an active task as stored in the tasks bucket
+type boltTaskRecord struct {
+	AgentID uint  `json:"agent_id"`
+	Task    *Task `json:"task"`
+}
+
+// Ensure BoltStore satisfies Repository and Journal
+var _ Repository = (*BoltStore)(nil)
+var _ Journal = (*BoltStore)(nil)
+
+// OpenBoltStore opens (or creates) the database at path and restores the store from it
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "bolt.Open(path)")
+	}
+
+	bs := &BoltStore{
+		Store: &Store{},
+		db:    db,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltBucketSkills, boltBucketAgents, boltBucketTasks, boltBucketPending, boltBucketCompleted, boltBucketCancelled, boltBucketIdxAgent, boltBucketIdxState, boltBucketHistory, boltBucketPresence, boltBucketMeta} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return errors.Wrapf(err, "tx.CreateBucketIfNotExists(%s)", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = bs.load()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "bs.load()")
+	}
+
+	// Only now that state is restored, start writing new mutations through
+	bs.Store.journal = bs
+
+	return bs, nil
+}
+
+// load rebuilds the in-memory working set from the database
+func (bs *BoltStore) load() error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		tasks := tx.Bucket(boltBucketTasks)
+
+		if v := tx.Bucket(boltBucketMeta).Get(boltKeyRetiredAgentID); v != nil {
+			bs.Store.retiredAgentID = btoi(v)
+		}
+		if v := tx.Bucket(boltBucketMeta).Get(boltKeyRetiredTaskID); v != nil {
+			bs.Store.retiredTaskID = btoi(v)
+		}
+
+		err := tx.Bucket(boltBucketSkills).ForEach(func(k, v []byte) error {
+			var sd SkillDefinition
+			err := json.Unmarshal(v, &sd)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(skill %s)", k)
+			}
+			bs.Store.putSkill(&sd)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketAgents).ForEach(func(k, v []byte) error {
+			var rec boltAgentRecord
+			err := json.Unmarshal(v, &rec)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(agent %d)", btoi(k))
+			}
+
+			agent := rec.Agent
+			agent.Tasks = make([]*Task, 0, len(rec.TaskIDs))
+			for _, taskID := range rec.TaskIDs {
+				var taskRec boltTaskRecord
+				err = json.Unmarshal(tasks.Get(itob(taskID)), &taskRec)
+				if err != nil {
+					return errors.Wrapf(err, "json.Unmarshal(task %d)", taskID)
+				}
+				agent.Tasks = append(agent.Tasks, taskRec.Task)
+			}
+			bs.Store.agents = append(bs.Store.agents, &agent)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketPending).ForEach(func(k, v []byte) error {
+			var task Task
+			err := json.Unmarshal(v, &task)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(pending task %d)", btoi(k))
+			}
+			bs.Store.insertPendingTask(&task)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketCompleted).ForEach(func(k, v []byte) error {
+			var task Task
+			err := json.Unmarshal(v, &task)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(completed task %d)", btoi(k))
+			}
+			bs.Store.completedTasks = append(bs.Store.completedTasks, &task)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketCancelled).ForEach(func(k, v []byte) error {
+			var task Task
+			err := json.Unmarshal(v, &task)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(cancelled task %d)", btoi(k))
+			}
+			bs.Store.cancelledTasks = append(bs.Store.cancelledTasks, &task)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketHistory).ForEach(func(k, v []byte) error {
+			var ev TaskEvent
+			err := json.Unmarshal(v, &ev)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(task %d history)", btoi(k[:8]))
+			}
+			bs.Store.recordEvents([]TaskEvent{ev})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketPresence).ForEach(func(k, v []byte) error {
+			var pc PresenceChange
+			err := json.Unmarshal(v, &pc)
+			if err != nil {
+				return errors.Wrapf(err, "json.Unmarshal(agent %d presence)", btoi(k[:8]))
+			}
+			bs.Store.recordPresenceChange(pc)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Tracef("BoltStore: Restored %d skills, %d agents, %d pending tasks, %d completed tasks, %d cancelled tasks", len(bs.Store.skills), len(bs.Store.agents), len(bs.Store.pendingTasks), len(bs.Store.completedTasks), len(bs.Store.cancelledTasks))
+		return nil
+	})
+}
+
+// Append writes the entry through to the database; it is called by the Store with its write lock held
+func (bs *BoltStore) Append(e *JournalEntry) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		err := boltPutEvents(tx, e.Events)
+		if err != nil {
+			return err
+		}
+
+		switch e.Op {
+		case OpAddAgent:
+			rec := boltAgentRecord{Agent: e.Agent.Clone()}
+			rec.Tasks = nil
+			for _, t := range e.Agent.Tasks {
+				err := boltPutActiveTask(tx, e.Agent.ID, t)
+				if err != nil {
+					return err
+				}
+				rec.TaskIDs = append(rec.TaskIDs, t.ID)
+			}
+			return boltPutAgent(tx, &rec)
+
+		case OpSetAgentSkills:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			rec.Skills = e.Agent.Skills
+			rec.SkillLevels = e.Agent.SkillLevels
+			return boltPutAgent(tx, rec)
+
+		case OpSetAgentCapacity:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			rec.Capacity = e.Agent.Capacity
+			return boltPutAgent(tx, rec)
+
+		case OpSetAgentSchedule:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			rec.Schedule = e.Agent.Schedule
+			return boltPutAgent(tx, rec)
+
+		case OpSetAgentPresence:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			rec.Presence = e.Presence.To
+			err = boltPutPresenceChange(tx, e.Presence)
+			if err != nil {
+				return err
+			}
+			return boltPutAgent(tx, rec)
+
+		case OpDeactivateAgent, OpDeleteAgent:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			err = boltReturnAgentTasks(tx, rec, e.Time)
+			if err != nil {
+				return err
+			}
+			if e.Op == OpDeactivateAgent {
+				rec.Deactivated = true
+				return boltPutAgent(tx, rec)
+			}
+			err = tx.Bucket(boltBucketAgents).Delete(itob(e.AgentID))
+			if err != nil {
+				return err
+			}
+			if v := tx.Bucket(boltBucketMeta).Get(boltKeyRetiredAgentID); v != nil && btoi(v) >= e.AgentID {
+				return nil
+			}
+			return tx.Bucket(boltBucketMeta).Put(boltKeyRetiredAgentID, itob(e.AgentID))
+
+		case OpActivateAgent:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			rec.Deactivated = false
+			return boltPutAgent(tx, rec)
+
+		case OpPushTask, OpUnshiftTask:
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			err = boltDeletePendingTask(tx, e.Task.ID)
+			if err != nil {
+				return err
+			}
+			err = boltPutActiveTask(tx, e.AgentID, e.Task)
+			if err != nil {
+				return err
+			}
+			if e.Op == OpPushTask {
+				rec.TaskIDs = append(rec.TaskIDs, e.Task.ID)
+			} else {
+				rec.TaskIDs = append([]uint{e.Task.ID}, rec.TaskIDs...)
+			}
+			err = boltPutAgent(tx, rec)
+			if err != nil {
+				return err
+			}
+			return boltMoveAheadOfLessUrgent(tx, e.AgentID, e.Task.ID)
+
+		case OpReassignTask:
+			_, err := boltDeleteActiveTask(tx, e.TaskID, e.Time)
+			if err != nil {
+				return err
+			}
+			rec, err := boltGetAgent(tx, e.AgentID)
+			if err != nil {
+				return err
+			}
+			err = boltPutActiveTask(tx, e.AgentID, e.Task)
+			if err != nil {
+				return err
+			}
+			rec.TaskIDs = append([]uint{e.Task.ID}, rec.TaskIDs...)
+			err = boltPutAgent(tx, rec)
+			if err != nil {
+				return err
+			}
+			return boltMoveAheadOfLessUrgent(tx, e.AgentID, e.Task.ID)
+
+		case OpReturnTask:
+			_, err := boltDeleteActiveTask(tx, e.TaskID, e.Time)
+			if err != nil {
+				return err
+			}
+			return boltPutPendingTask(tx, e.Task)
+
+		case OpCompleteTask:
+			agentID, err := boltDeleteActiveTask(tx, e.TaskID, e.Time)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(e.Task)
+			if err != nil {
+				return errors.Wrap(err, "json.Marshal(task)")
+			}
+			err = tx.Bucket(boltBucketCompleted).Put(itob(e.TaskID), data)
+			if err != nil {
+				return err
+			}
+			return boltPutIndexes(tx, agentID, e.Task)
+
+		case OpCancelTask:
+			// The task is either held by an agent or still waiting
+			agentID, err := boltDeleteActiveTask(tx, e.TaskID, e.Time)
+			if err == ErrTaskNotFound {
+				if tx.Bucket(boltBucketPending).Get(itob(e.TaskID)) == nil {
+					return ErrTaskNotFound
+				}
+				err = boltDeletePendingTask(tx, e.TaskID)
+			}
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(e.Task)
+			if err != nil {
+				return errors.Wrap(err, "json.Marshal(task)")
+			}
+			err = tx.Bucket(boltBucketCancelled).Put(itob(e.TaskID), data)
+			if err != nil {
+				return err
+			}
+			if agentID == 0 {
+				return tx.Bucket(boltBucketIdxState).Put(indexKey(uint(e.Task.State), e.TaskID), nil)
+			}
+			return boltPutIndexes(tx, agentID, e.Task)
+
+		case OpDeleteTask:
+			_, err := boltDeleteActiveTask(tx, e.TaskID, e.Time)
+			if err != nil {
+				return err
+			}
+			if v := tx.Bucket(boltBucketMeta).Get(boltKeyRetiredTaskID); v != nil && btoi(v) >= e.TaskID {
+				return nil
+			}
+			return tx.Bucket(boltBucketMeta).Put(boltKeyRetiredTaskID, itob(e.TaskID))
+
+		case OpSetTaskState:
+			rec, err := boltGetActiveTask(tx, e.TaskID)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(boltBucketIdxState).Delete(indexKey(uint(rec.Task.State), e.TaskID))
+			if err != nil {
+				return err
+			}
+			rec.Task.State = e.Task.State
+			return boltPutActiveTask(tx, rec.AgentID, rec.Task)
+
+		case OpSetTaskSLA:
+			return boltUpdateOpenTask(tx, e.TaskID, func(t *Task) {
+				t.SLAStatus = e.Task.SLAStatus
+			})
+
+		case OpSetTaskPriority:
+			err := boltUpdateOpenTask(tx, e.TaskID, func(t *Task) {
+				t.Priority = e.Task.Priority
+			})
+			if err != nil {
+				return err
+			}
+			// A held task moves up its holder's queue
+			rec, err := boltGetActiveTask(tx, e.TaskID)
+			if err == ErrTaskNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return boltMoveAheadOfLessUrgent(tx, rec.AgentID, e.TaskID)
+
+		case OpReopenTask:
+			bucket, state := boltBucketCompleted, TaskComplete
+			if tx.Bucket(bucket).Get(itob(e.TaskID)) == nil {
+				bucket, state = boltBucketCancelled, TaskCancelled
+			}
+			if tx.Bucket(bucket).Get(itob(e.TaskID)) == nil {
+				return ErrTaskNotFound
+			}
+			err := tx.Bucket(bucket).Delete(itob(e.TaskID))
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(boltBucketIdxState).Delete(indexKey(uint(state), e.TaskID))
+			if err != nil {
+				return err
+			}
+			return boltPutPendingTask(tx, e.Task)
+
+		case OpEnqueueTask:
+			return boltPutPendingTask(tx, e.Task)
+
+		case OpPutSkill:
+			data, err := json.Marshal(e.Skill)
+			if err != nil {
+				return errors.Wrap(err, "json.Marshal(skill)")
+			}
+			return tx.Bucket(boltBucketSkills).Put([]byte(e.Skill.Name), data)
+
+		case OpDeleteSkill:
+			return tx.Bucket(boltBucketSkills).Delete([]byte(e.Skill.Name))
+
+		default:
+			return fmt.Errorf("Unknown journal op: %v", e.Op)
+		}
+	})
+}
+
+// FindTask returns a copy of the active task with the given ID, via the tasks bucket
+func (bs *BoltStore) FindTask(taskID uint) (*Task, error) {
+	var rec *boltTaskRecord
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = boltGetActiveTask(tx, taskID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec.Task, nil
+}
+
+// FindTaskWithAgent returns the active task with the given ID and its assigned agent, via the tasks bucket
+func (bs *BoltStore) FindTaskWithAgent(taskID uint) (Task, error) {
+	var result Task
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		rec, err := boltGetActiveTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		agentRec, err := boltGetAgent(tx, rec.AgentID)
+		if err != nil {
+			return err
+		}
+
+		result = rec.Task.Clone()
+		agent := agentRec.Agent.SlimClone()
+		result.AssignedAgent = &agent
+		return nil
+	})
+
+	return result, err
+}
+
+// NextTaskID returns the next available task ID, from the highest key across the task buckets
+// and the highest ID of any deleted task
+func (bs *BoltStore) NextTaskID() uint {
+	id := uint(1)
+	_ = bs.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltBucketMeta).Get(boltKeyRetiredTaskID); v != nil {
+			id = btoi(v) + 1
+		}
+		for _, name := range [][]byte{boltBucketTasks, boltBucketPending, boltBucketCompleted, boltBucketCancelled} {
+			k, _ := tx.Bucket(name).Cursor().Last()
+			if k != nil && btoi(k) >= id {
+				id = btoi(k) + 1
+			}
+		}
+		return nil
+	})
+	return id
+}
+
+// TaskIDsByAgent returns the IDs of every task (active, completed or cancelled) ever assigned to the agent, via the agent index
+func (bs *BoltStore) TaskIDsByAgent(agentID uint) ([]uint, error) {
+	return bs.scanIndex(boltBucketIdxAgent, agentID)
+}
+
+// TaskIDsByState returns the IDs of every task currently in the given state, via the state index
+func (bs *BoltStore) TaskIDsByState(state TaskState) ([]uint, error) {
+	return bs.scanIndex(boltBucketIdxState, uint(state))
+}
+
+func (bs *BoltStore) scanIndex(bucket []byte, prefix uint) ([]uint, error) {
+	ids := []uint{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		p := itob(prefix)
+		for k, _ := c.Seek(p); k != nil && len(k) == 16 && btoi(k[:8]) == prefix; k, _ = c.Next() {
+			ids = append(ids, btoi(k[8:]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// Close releases the database file
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+func boltGetAgent(tx *bolt.Tx, agentID uint) (*boltAgentRecord, error) {
+	data := tx.Bucket(boltBucketAgents).Get(itob(agentID))
+	if data == nil {
+		return nil, ErrAgentNotFound
+	}
+	var rec boltAgentRecord
+	err := json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal(agent)")
+	}
+	return &rec, nil
+}
+
+func boltPutAgent(tx *bolt.Tx, rec *boltAgentRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(agent)")
+	}
+	return tx.Bucket(boltBucketAgents).Put(itob(rec.ID), data)
+}
+
+func boltGetActiveTask(tx *bolt.Tx, taskID uint) (*boltTaskRecord, error) {
+	data := tx.Bucket(boltBucketTasks).Get(itob(taskID))
+	if data == nil {
+		return nil, ErrTaskNotFound
+	}
+	var rec boltTaskRecord
+	err := json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal(task)")
+	}
+	return &rec, nil
+}
+
+func boltPutActiveTask(tx *bolt.Tx, agentID uint, t *Task) error {
+	data, err := json.Marshal(boltTaskRecord{AgentID: agentID, Task: t})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(task)")
+	}
+	err = tx.Bucket(boltBucketTasks).Put(itob(t.ID), data)
+	if err != nil {
+		return err
+	}
+	return boltPutIndexes(tx, agentID, t)
+}
+
+// boltDeleteActiveTask removes an active task, its state index entry and its place in the agent's queue
+func boltDeleteActiveTask(tx *bolt.Tx, taskID uint, at time.Time) (agentID uint, err error) {
+	rec, err := boltGetActiveTask(tx, taskID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Bucket(boltBucketTasks).Delete(itob(taskID))
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Bucket(boltBucketIdxState).Delete(indexKey(uint(rec.Task.State), taskID))
+	if err != nil {
+		return 0, err
+	}
+
+	agentRec, err := boltGetAgent(tx, rec.AgentID)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range agentRec.TaskIDs {
+		if id == taskID {
+			agentRec.TaskIDs = append(agentRec.TaskIDs[:i], agentRec.TaskIDs[i+1:]...)
+			break
+		}
+	}
+	if len(agentRec.TaskIDs) == 0 {
+		agentRec.IdleSince = at
+	}
+
+	return rec.AgentID, boltPutAgent(tx, agentRec)
+}
+
+// boltReturnAgentTasks moves an agent's active tasks to the pending bucket, emptying the agent's queue
+func boltReturnAgentTasks(tx *bolt.Tx, agentRec *boltAgentRecord, at time.Time) error {
+	if len(agentRec.TaskIDs) == 0 {
+		return nil
+	}
+	for _, taskID := range agentRec.TaskIDs {
+		rec, err := boltGetActiveTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltBucketTasks).Delete(itob(taskID))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltBucketIdxState).Delete(indexKey(uint(rec.Task.State), taskID))
+		if err != nil {
+			return err
+		}
+
+		rec.Task.AssignmentTime = time.Time{}
+		rec.Task.State = TaskQueued
+		err = boltPutPendingTask(tx, rec.Task)
+		if err != nil {
+			return err
+		}
+	}
+	agentRec.TaskIDs = nil
+	agentRec.IdleSince = at
+	return nil
+}
+
+// boltPutPendingTask writes a waiting task to the pending bucket and indexes it by state
+func boltPutPendingTask(tx *bolt.Tx, t *Task) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(task)")
+	}
+	err = tx.Bucket(boltBucketPending).Put(itob(t.ID), data)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucketIdxState).Put(indexKey(uint(t.State), t.ID), nil)
+}
+
+// boltDeletePendingTask removes a task (if present) from the pending bucket and its state index entry
+func boltDeletePendingTask(tx *bolt.Tx, taskID uint) error {
+	data := tx.Bucket(boltBucketPending).Get(itob(taskID))
+	if data == nil {
+		return nil
+	}
+	var t Task
+	err := json.Unmarshal(data, &t)
+	if err != nil {
+		return errors.Wrap(err, "json.Unmarshal(pending task)")
+	}
+	err = tx.Bucket(boltBucketPending).Delete(itob(taskID))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucketIdxState).Delete(indexKey(uint(t.State), taskID))
+}
+
+// boltMoveAheadOfLessUrgent reorders the agent's queue as moveAheadOfLessUrgent, for the held task
+func boltMoveAheadOfLessUrgent(tx *bolt.Tx, agentID, taskID uint) error {
+	agentRec, err := boltGetAgent(tx, agentID)
+	if err != nil {
+		return err
+	}
+	tasks := make([]*Task, 0, len(agentRec.TaskIDs))
+	var moved *Task
+	for _, id := range agentRec.TaskIDs {
+		rec, err := boltGetActiveTask(tx, id)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, rec.Task)
+		if id == taskID {
+			moved = rec.Task
+		}
+	}
+	if moved == nil {
+		return ErrTaskNotFound
+	}
+	agentRec.TaskIDs = agentRec.TaskIDs[:0]
+	for _, t := range moveAheadOfLessUrgent(tasks, moved) {
+		agentRec.TaskIDs = append(agentRec.TaskIDs, t.ID)
+	}
+	return boltPutAgent(tx, agentRec)
+}
+
+// boltUpdateOpenTask rewrites an active or pending task, changed by update, in place
+func boltUpdateOpenTask(tx *bolt.Tx, taskID uint, update func(t *Task)) error {
+	rec, err := boltGetActiveTask(tx, taskID)
+	if err == ErrTaskNotFound {
+		data := tx.Bucket(boltBucketPending).Get(itob(taskID))
+		if data == nil {
+			return ErrTaskNotFound
+		}
+		var t Task
+		err = json.Unmarshal(data, &t)
+		if err != nil {
+			return errors.Wrap(err, "json.Unmarshal(pending task)")
+		}
+		update(&t)
+		return boltPutPendingTask(tx, &t)
+	}
+	if err != nil {
+		return err
+	}
+	update(rec.Task)
+	return boltPutActiveTask(tx, rec.AgentID, rec.Task)
+}
+
+// boltPutEvents appends events to the history bucket, keyed by task ID then insertion order
+func boltPutEvents(tx *bolt.Tx, events []TaskEvent) error {
+	b := tx.Bucket(boltBucketHistory)
+	for _, ev := range events {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal(event)")
+		}
+		err = b.Put(indexKey(ev.TaskID, uint(seq)), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltPutPresenceChange appends a change to the presence log bucket, keyed by agent ID then insertion order
+func boltPutPresenceChange(tx *bolt.Tx, pc *PresenceChange) error {
+	b := tx.Bucket(boltBucketPresence)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(pc)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(presence)")
+	}
+	return b.Put(indexKey(pc.AgentID, uint(seq)), data)
+}
+
+func boltPutIndexes(tx *bolt.Tx, agentID uint, t *Task) error {
+	err := tx.Bucket(boltBucketIdxAgent).Put(indexKey(agentID, t.ID), nil)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucketIdxState).Put(indexKey(uint(t.State), t.ID), nil)
+}
+
+// itob encodes an ID as a big-endian key, so that keys sort numerically
+func itob(v uint) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) uint {
+	return uint(binary.BigEndian.Uint64(b))
+}
+
+// indexKey builds a composite secondary index key: prefix (e.g. agent ID or state) + task ID
+func indexKey(prefix uint, taskID uint) []byte {
+	return append(itob(prefix), itob(taskID)...)
+}
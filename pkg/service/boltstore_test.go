@@ -0,0 +1,295 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BoltStore_Recovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffn-boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	// Build up some state: 3 skills, 3 agents, 3 tasks, 1 of which is completed
+	bs, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sd := range BuildSeedSkills() {
+		err = bs.AddSkill(*sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = bs.AddAgents(BuildSeedAgents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range []*Task{
+		&Task{Priority: PriorityLow, ReqSkills: Skills{Skill1}},
+		&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill3}},
+		&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill2}},
+	} {
+		_, _, err = bs.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = bs.MarkAsCompleted(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.StartTask(1, ChangeInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.SetAgentCapacity(2, Capacity{MaxTasks: 3, MaxTasksByPriority: map[Priority]int{PriorityHigh: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.SetAgentPresence(3, PresenceAway, "charlie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.SetAgentSchedule(3, &Schedule{TimeZone: "Europe/London", Shifts: []Shift{{Day: Weekday(time.Monday), Start: "09:00", End: "17:00"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAgents, _ := bs.ListAgents()
+	err = bs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	gotAgents, _ := restored.ListAgents()
+	assertSameAgents(t, wantAgents, gotAgents)
+	gotCompleted, _ := restored.ListCompletedTasks()
+	assert.Equal(t, 1, len(gotCompleted))
+	assert.Equal(t, uint(4), restored.NextTaskID())
+	gotSkills, _ := restored.ListSkills()
+	assert.Equal(t, 3, len(gotSkills))
+
+	// Indexed lookups
+	task, err := restored.FindTaskWithAgent(3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Adam", task.AssignedAgent.Name)
+		assert.Equal(t, PriorityHigh, task.Priority)
+	}
+	_, err = restored.FindTaskWithAgent(2)
+	assert.Error(t, err)
+
+	byAgent, _ := restored.TaskIDsByAgent(1)
+	assert.Equal(t, []uint{1, 3}, byAgent)
+	byState, _ := restored.TaskIDsByState(TaskComplete)
+	assert.Equal(t, []uint{2}, byState)
+	byState, _ = restored.TaskIDsByState(TaskInWIP)
+	assert.Equal(t, []uint{1}, byState)
+	byState, _ = restored.TaskIDsByState(TaskAssigned)
+	assert.Equal(t, []uint{3}, byState)
+
+	// Betty takes over task 3; then offboarding: Charlie is deleted outright,
+	// and Adam is deactivated, returning his remaining task to the pool
+	agentID, err := restored.ReassignTask(3, ReassignOptions{AgentID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint(2), agentID)
+	err = restored.DeleteAgent(3, InFlightBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restored.DeactivateAgent(1, InFlightReturn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An hour on, both the waiting and the held task are past their SLA targets. The
+	// waiting task was assigned once already, so it met its time to assign back then.
+	err = restored.SetSLAPolicy(SLAPolicy{Targets: map[Priority]SLATarget{
+		PriorityLow:  {TimeToAssignSeconds: 60, TimeToCompleteSeconds: 60},
+		PriorityHigh: {TimeToCompleteSeconds: 60},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restored.CheckSLAs(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAgents, _ = restored.ListAgents()
+	err = restored.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoredAgain, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredAgain.Close()
+
+	gotAgents, _ = restoredAgain.ListAgents()
+	assertSameAgents(t, wantAgents, gotAgents)
+	if assert.Equal(t, 2, len(gotAgents)) {
+		assert.True(t, gotAgents[0].Deactivated)
+		assert.Equal(t, 0, len(gotAgents[0].Tasks))
+	}
+	pending, _ := restoredAgain.ListPendingTasks()
+	if assert.Equal(t, 1, len(pending)) {
+		assert.Equal(t, uint(1), pending[0].ID)
+		assert.Equal(t, TaskQueued, pending[0].State)
+		assert.Equal(t, SLABreached, pending[0].SLAStatus)
+	}
+	task, err = restoredAgain.FindTaskWithAgent(3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Betty", task.AssignedAgent.Name)
+		assert.Equal(t, SLABreached, task.SLAStatus)
+	}
+	assert.Equal(t, uint(4), restoredAgain.NextAgentID())
+	// Charlie's presence log outlives him
+	changes, err := restoredAgain.PresenceLog(3)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(changes)) {
+		assert.Equal(t, PresenceAway, changes[0].To)
+		assert.Equal(t, "charlie", changes[0].Actor)
+	}
+	assertHistoryKinds(t, restoredAgain.Store, 1, TaskEventCreated, TaskEventAssigned, TaskEventStateChanged, TaskEventReturned, TaskEventSLAChanged)
+	assertHistoryKinds(t, restoredAgain.Store, 3, TaskEventCreated, TaskEventAssigned, TaskEventReassigned, TaskEventSLAChanged)
+
+	// Cancel both the waiting task and Betty's; neither counts as completed
+	for _, taskID := range []uint{1, 3} {
+		err = restoredAgain.CancelTask(taskID, ChangeInfo{Reason: "Duplicate"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = restoredAgain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoredCancelled, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredCancelled.Close()
+
+	gotCancelled, _ := restoredCancelled.ListCancelledTasks()
+	if assert.Equal(t, 2, len(gotCancelled)) {
+		assert.Equal(t, "Duplicate", gotCancelled[0].CancelReason)
+	}
+	gotCompleted, _ = restoredCancelled.ListCompletedTasks()
+	assert.Equal(t, 1, len(gotCompleted))
+	pending, _ = restoredCancelled.ListPendingTasks()
+	assert.Equal(t, 0, len(pending))
+	byState, _ = restoredCancelled.TaskIDsByState(TaskCancelled)
+	assert.Equal(t, []uint{1, 3}, byState)
+	byState, _ = restoredCancelled.TaskIDsByState(TaskAssigned)
+	assert.Equal(t, []uint{}, byState)
+	assert.Equal(t, uint(4), restoredCancelled.NextTaskID())
+
+	// A deleted task's ID is never reissued, even once it is the highest
+	_, taskID, err := restoredCancelled.AddTaskToAgent(&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restoredCancelled.DeleteTask(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint(5), restoredCancelled.NextTaskID())
+	assert.Equal(t, uint(5), restoredCancelled.Store.NextTaskID())
+}
+
+func Test_BoltStore_RaisedTaskMovesUpItsQueue(t *testing.T) {
+	levels, err := ParsePriorityLevels("high,normal,low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ConfigurePriorities(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigurePriorities(DefaultPriorityLevels())
+
+	dir, err := ioutil.TempDir("", "ffn-boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	// Betty takes a low task, then a normal one in front of it
+	bs, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sd := range BuildSeedSkills() {
+		err = bs.AddSkill(*sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = bs.AddAgents(BuildSeedAgents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for _, task := range []*Task{
+		&Task{Priority: "low", ReqSkills: Skills{Skill3}},
+		&Task{Priority: "normal", ReqSkills: Skills{Skill3}},
+	} {
+		_, _, err = bs.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue := func(s *Store) []uint {
+		agent, err := s.FindAgent(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []uint{}
+		for _, task := range agent.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []uint{2, 1}, queue(bs.Store))
+
+	// Raised to high, the low task moves in front of the normal one, on disk as in memory
+	err = bs.SetEscalationRules([]EscalationRule{
+		{Name: "stuck-low", State: TaskAssigned, AfterSeconds: 600, Priority: "low", Action: EscalateRaisePriority, ToPriority: "high"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.Escalate(start.Add(11 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []uint{1, 2}, queue(bs.Store))
+	err = bs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	assert.Equal(t, []uint{1, 2}, queue(restored.Store))
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+)
+
+// Capacity limits how many tasks an agent may hold at once, e.g. five for a
+// senior agent and one for a trainee. Within their limit, agents stack tasks of any
+// priority; without one, they take at most one task of each rank (see blockingTask).
+// The zero value imposes no limit.
+type Capacity struct {
+	// MaxTasks caps the agent's queue as a whole
+	MaxTasks int `json:"max_tasks,omitempty"`
+
+	// MaxTasksByPriority replaces MaxTasks for tasks of the given priority: the agent
+	// may only take such a task while holding fewer than this many tasks. It may be
+	// higher than MaxTasks, e.g. to let a trainee take an urgent task alongside another.
+	// Zero is the same as no entry, so MaxTasks applies.
+	MaxTasksByPriority map[Priority]int `json:"max_tasks_by_priority,omitempty"`
+}
+
+func (c *Capacity) IsValid() error {
+	if c.MaxTasks < 0 {
+		return fmt.Errorf("Invalid max_tasks: %d", c.MaxTasks)
+	}
+	for p, max := range c.MaxTasksByPriority {
+		if err := p.IsValid(); err != nil {
+			return err
+		}
+		if max < 0 {
+			return fmt.Errorf("Invalid max_tasks for priority %v: %d", p, max)
+		}
+	}
+	return nil
+}
+
+// limit returns how many tasks an agent may hold to take a task of priority p, or 0 for no limit
+func (c *Capacity) limit(p Priority) int {
+	if m := c.MaxTasksByPriority[p]; m > 0 {
+		return m
+	}
+	return c.MaxTasks
+}
+
+// allows reports whether an agent holding tasks has room for another task of priority p
+func (c *Capacity) allows(tasks []*Task, p Priority) bool {
+	max := c.limit(p)
+	return max == 0 || len(tasks) < max
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompletedTaskSort names the time completed tasks are ordered by
+type CompletedTaskSort string
+
+const (
+	SortByCompletedTime  CompletedTaskSort = "completed_time"
+	SortByAssignmentTime CompletedTaskSort = "assignment_time"
+)
+
+const (
+	DefaultCompletedPageSize = 50
+	MaxCompletedPageSize     = 500
+)
+
+var ErrInvalidCursor = fmt.Errorf("Invalid cursor")
+
+// ParseCompletedTaskSort parses a sort order such as "completed_time", or
+// "-assignment_time" for descending order; an empty string is completed_time, oldest first
+func ParseCompletedTaskSort(s string) (by CompletedTaskSort, descending bool, err error) {
+	if strings.HasPrefix(s, "-") {
+		descending = true
+		s = s[1:]
+	}
+	switch CompletedTaskSort(s) {
+	case "", SortByCompletedTime:
+		return SortByCompletedTime, descending, nil
+	case SortByAssignmentTime:
+		return SortByAssignmentTime, descending, nil
+	}
+	return "", false, fmt.Errorf("Invalid sort: %v (available: %s, %s)", s, SortByCompletedTime, SortByAssignmentTime)
+}
+
+// CompletedTaskQuery selects a page of completed tasks; zero-valued filters match every task
+type CompletedTaskQuery struct {
+	AgentID  uint
+	Priority Priority
+	Skill    Skill
+
+	// CompletedAfter and CompletedBefore bound the completion time, inclusive and exclusive respectively
+	CompletedAfter  time.Time
+	CompletedBefore time.Time
+
+	SortBy     CompletedTaskSort
+	Descending bool
+
+	// Cursor continues from the end of a previous page, as returned in its NextCursor
+	Cursor string
+	// Limit is the page size; DefaultCompletedPageSize if zero, at most MaxCompletedPageSize
+	Limit int
+}
+
+// CompletedTaskPage is one page of completed tasks; NextCursor is empty on the last page
+type CompletedTaskPage struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// matches reports whether the completed task passes the query's filters
+func (q *CompletedTaskQuery) matches(t *Task) bool {
+	if q.AgentID != 0 && (t.AssignedAgent == nil || t.AssignedAgent.ID != q.AgentID) {
+		return false
+	}
+	if q.Priority != "" && t.Priority != q.Priority {
+		return false
+	}
+	if q.Skill != "" && !t.ReqSkills.Includes(q.Skill) {
+		return false
+	}
+	if !q.CompletedAfter.IsZero() && t.CompletedTime.Before(q.CompletedAfter) {
+		return false
+	}
+	if !q.CompletedBefore.IsZero() && !t.CompletedTime.Before(q.CompletedBefore) {
+		return false
+	}
+	return true
+}
+
+// sortKey returns the time the task is ordered by
+func (q *CompletedTaskQuery) sortKey(t *Task) time.Time {
+	if q.SortBy == SortByAssignmentTime {
+		return t.AssignmentTime
+	}
+	return t.CompletedTime
+}
+
+// completedCursor is the position after the last task of a page: its sort key, then its ID
+type completedCursor struct {
+	key time.Time
+	id  uint
+}
+
+func (c completedCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d,%s", c.id, c.key.Format(time.RFC3339Nano))))
+}
+
+func decodeCompletedCursor(s string) (completedCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return completedCursor{}, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(data), ",", 2)
+	if len(parts) != 2 {
+		return completedCursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return completedCursor{}, ErrInvalidCursor
+	}
+	key, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return completedCursor{}, ErrInvalidCursor
+	}
+	return completedCursor{key: key, id: uint(id)}, nil
+}
+
+// QueryCompletedTasks returns a filtered, sorted page of completed tasks. Pages
+// are keyed on the last task returned, rather than an offset, so tasks completed
+// while a client is paging do not shift the pages it has yet to read.
+func (s *Store) QueryCompletedTasks(q CompletedTaskQuery) (CompletedTaskPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultCompletedPageSize
+	}
+	if q.Limit > MaxCompletedPageSize {
+		q.Limit = MaxCompletedPageSize
+	}
+	var after *completedCursor
+	if q.Cursor != "" {
+		c, err := decodeCompletedCursor(q.Cursor)
+		if err != nil {
+			return CompletedTaskPage{}, err
+		}
+		after = &c
+	}
+
+	// less orders tasks by the sort key, then by ID so that every position is unique
+	less := func(aKey time.Time, aID uint, bKey time.Time, bID uint) bool {
+		if !aKey.Equal(bKey) {
+			if q.Descending {
+				return aKey.After(bKey)
+			}
+			return aKey.Before(bKey)
+		}
+		if q.Descending {
+			return aID > bID
+		}
+		return aID < bID
+	}
+
+	s.RLock()
+	matched := []Task{}
+	for _, t := range s.completedTasks {
+		if !q.matches(t) {
+			continue
+		}
+		if after != nil && !less(after.key, after.id, q.sortKey(t), t.ID) {
+			continue
+		}
+		matched = append(matched, t.Clone())
+	}
+	s.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return less(q.sortKey(&matched[i]), matched[i].ID, q.sortKey(&matched[j]), matched[j].ID)
+	})
+
+	if len(matched) <= q.Limit {
+		return CompletedTaskPage{Tasks: matched}, nil
+	}
+	last := &matched[q.Limit-1]
+	return CompletedTaskPage{
+		Tasks:      matched[:q.Limit],
+		NextCursor: completedCursor{key: q.sortKey(last), id: last.ID}.encode(),
+	}, nil
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DryRunOutcome is what AddTaskToAgent would do with a task
+type DryRunOutcome string
+
+const (
+	DryRunAssigned DryRunOutcome = "assigned" // Assigned to the top-ranked agent
+	DryRunQueued   DryRunOutcome = "queued"   // Parked in the pending queue until an agent is available
+	DryRunRejected DryRunOutcome = "rejected" // Refused, as no agent could ever take it
+)
+
+// AgentEvaluation is how a single agent fared in selection for a task
+type AgentEvaluation struct {
+	AgentID uint   `json:"agent_id"`
+	Name    string `json:"name"`
+
+	// EliminatedBy is the first stage that ruled the agent out, with the reason; empty if they remain a candidate
+	EliminatedBy FilterStage `json:"eliminated_by,omitempty"`
+	Reason       string      `json:"reason,omitempty"`
+
+	// Rank orders the remaining candidates, 1 being the agent selected
+	Rank int `json:"rank,omitempty"`
+	// BestMatch is set for candidates with the most preferred skills and the closest proficiency
+	// match; the strategy only chooses between these
+	BestMatch       bool `json:"best_match,omitempty"`
+	PreferredSkills int  `json:"preferred_skills,omitempty"`
+	MatchScore      int  `json:"match_score"`
+}
+
+// AssignmentDryRun reports what AddTaskToAgent would do with a task, and why
+type AssignmentDryRun struct {
+	Strategy string        `json:"strategy"`
+	Outcome  DryRunOutcome `json:"outcome"`
+	// Error is the error AddTaskToAgent would return, or the reason a task would be queued
+	Error string `json:"error,omitempty"`
+	// SelectedAgentID is the agent the task would be assigned to, if any
+	SelectedAgentID uint `json:"selected_agent_id,omitempty"`
+
+	// Agents lists every agent in the store, with the stage that eliminated them
+	Agents []AgentEvaluation `json:"agents"`
+	// Ranking lists the remaining candidates, best first
+	Ranking []AgentEvaluation `json:"ranking"`
+}
+
+// DryRunAssignment runs the task through the selection stages of AddTaskToAgentWithOptions,
+// without changing the store. Where the strategy would choose between equals at random
+// (see AgentRanker), the ranking keeps them in store order, so the agent selected for
+// real may differ from the one reported.
+func (s *Store) DryRunAssignment(t *Task, opts AssignmentOptions) (AssignmentDryRun, error) {
+	// Ensure task is valid
+	err := t.IsValid()
+	if err != nil {
+		return AssignmentDryRun{}, errors.Wrap(err, "task.IsValid()")
+	}
+	err = s.ValidateSkills(t.ReqSkills)
+	if err != nil {
+		return AssignmentDryRun{}, errors.Wrap(err, "s.ValidateSkills()")
+	}
+	err = s.ValidateSkills(t.PrefSkills)
+	if err != nil {
+		return AssignmentDryRun{}, errors.Wrap(err, "s.ValidateSkills()")
+	}
+
+	strategy := opts.Strategy
+	if strategy == nil {
+		strategy = s.assignmentStrategy()
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	run := AssignmentDryRun{Strategy: strategy.Name(), Ranking: []AgentEvaluation{}}
+	evals, candidates, err := s.evaluateAgents(t, time.Now(), 0)
+	run.Agents = evals
+	switch err {
+	case nil:
+	case ErrNoAvailableAgents:
+		run.Outcome, run.Error = DryRunQueued, err.Error()
+		return run, nil
+	case ErrNoSkilledAgents:
+		run.Outcome, run.Error = DryRunRejected, err.Error()
+		return run, nil
+	default:
+		return AssignmentDryRun{}, err
+	}
+
+	ranked, best := rankCandidates(t, candidates, strategy)
+	ranks := map[uint]int{}
+	for i, a := range ranked {
+		ranks[a.ID] = i + 1
+		run.Ranking = append(run.Ranking, AgentEvaluation{
+			AgentID:         a.ID,
+			Name:            a.Name,
+			Rank:            i + 1,
+			BestMatch:       i < best,
+			PreferredSkills: a.PreferredSkillCount(t),
+			MatchScore:      a.MatchScore(t),
+		})
+	}
+	for i := range run.Agents {
+		if rank := ranks[run.Agents[i].AgentID]; rank > 0 {
+			run.Agents[i] = run.Ranking[rank-1]
+		}
+	}
+	run.Outcome, run.SelectedAgentID = DryRunAssigned, ranked[0].ID
+
+	return run, nil
+}
+
+// rankCandidates orders the candidates for the task, best first: by preferred skills,
+// then proficiency match, then as the strategy would choose between them. It also
+// returns how many lead the ranking as best matches (see FilterForBestMatch).
+func rankCandidates(t *Task, candidates Agents, strategy AssignmentStrategy) (ranked Agents, best int) {
+	// Group candidates into tiers of equal match, keeping their order within each
+	type tier struct {
+		preferred, score int
+		agents           Agents
+	}
+	tiers := []*tier{}
+	for _, a := range candidates {
+		preferred, score := a.PreferredSkillCount(t), a.MatchScore(t)
+		var found *tier
+		for _, tr := range tiers {
+			if tr.preferred == preferred && tr.score == score {
+				found = tr
+			}
+		}
+		if found == nil {
+			found = &tier{preferred: preferred, score: score}
+			tiers = append(tiers, found)
+		}
+		found.agents = append(found.agents, a)
+	}
+	sort.SliceStable(tiers, func(i, j int) bool {
+		if tiers[i].preferred != tiers[j].preferred {
+			return tiers[i].preferred > tiers[j].preferred
+		}
+		return tiers[i].score > tiers[j].score
+	})
+
+	ranker, ok := strategy.(AgentRanker)
+	ranked = Agents{}
+	for _, tr := range tiers {
+		if ok {
+			tr.agents = ranker.RankAgents(t, tr.agents)
+		}
+		ranked = append(ranked, tr.agents...)
+	}
+	return ranked, len(tiers[0].agents)
+}
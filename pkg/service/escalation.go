@@ -0,0 +1,288 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// EscalationAction is what an escalation rule does to a stale task
+type EscalationAction string
+
+const (
+	EscalateRaisePriority EscalationAction = "raise_priority" // Make the task more urgent
+	EscalateReroute       EscalationAction = "reroute"        // Hand the task to an agent in a pool, e.g. supervisors
+)
+
+// EscalationRule escalates tasks that have spent too long in a state
+type EscalationRule struct {
+	// Name identifies the rule in the history of the tasks it escalates
+	Name string `json:"name"`
+
+	// The rule applies to tasks that have been in State for at least AfterSeconds,
+	// and only to tasks of Priority, if set
+	State        TaskState `json:"task_state"`
+	AfterSeconds int       `json:"after_seconds"`
+	Priority     Priority  `json:"priority,omitempty"`
+
+	Action EscalationAction `json:"action"`
+	// ToPriority is the priority raise_priority sets; the next more urgent level if empty
+	ToPriority Priority `json:"to_priority,omitempty"`
+	// PoolAgentIDs are the agents reroute chooses between. Pool agents are assumed
+	// to be able to handle any task, so the task's required skills are not checked.
+	PoolAgentIDs []uint `json:"pool_agent_ids,omitempty"`
+}
+
+func (r *EscalationRule) IsValid() error {
+	if r.Name == "" {
+		return fmt.Errorf("Escalation rule name is required")
+	}
+	if _, ok := taskStateNames[r.State]; !ok || r.State == TaskComplete || r.State == TaskCancelled {
+		return fmt.Errorf("Invalid escalation rule %q: task_state must be an open state", r.Name)
+	}
+	if r.AfterSeconds <= 0 {
+		return fmt.Errorf("Invalid escalation rule %q: after_seconds must be positive", r.Name)
+	}
+	if r.Priority != "" {
+		if err := r.Priority.IsValid(); err != nil {
+			return errors.Wrapf(err, "Escalation rule %q", r.Name)
+		}
+	}
+	switch r.Action {
+	case EscalateRaisePriority:
+		if r.ToPriority != "" {
+			if err := r.ToPriority.IsValid(); err != nil {
+				return errors.Wrapf(err, "Escalation rule %q", r.Name)
+			}
+		}
+	case EscalateReroute:
+		if len(r.PoolAgentIDs) == 0 {
+			return fmt.Errorf("Invalid escalation rule %q: reroute needs pool_agent_ids", r.Name)
+		}
+	default:
+		return fmt.Errorf("Invalid escalation rule %q: unknown action %q (expected %s or %s)", r.Name, r.Action, EscalateRaisePriority, EscalateReroute)
+	}
+	return nil
+}
+
+// raisedPriority returns the priority the rule raises the task to, if it is more urgent than the task's
+func (r *EscalationRule) raisedPriority(t *Task) (Priority, bool) {
+	to := r.ToPriority
+	if to == "" {
+		// Levels are listed most urgent first, so the next level up precedes the task's
+		levels := PriorityLevels()
+		for i := len(levels) - 1; i > 0; i-- {
+			if levels[i].Name == t.Priority {
+				to = levels[i-1].Name
+			}
+		}
+	}
+	return to, to != "" && to.Rank() > t.Priority.Rank()
+}
+
+// SetEscalationRules replaces the rules stale tasks are escalated by
+func (s *Store) SetEscalationRules(rules []EscalationRule) error {
+	seen := map[string]bool{}
+	for i := range rules {
+		err := rules[i].IsValid()
+		if err != nil {
+			return err
+		}
+		if seen[rules[i].Name] {
+			return fmt.Errorf("Duplicate escalation rule: %q", rules[i].Name)
+		}
+		seen[rules[i].Name] = true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.escalationRules = append([]EscalationRule{}, rules...)
+	return nil
+}
+
+// EscalationRules returns the rules stale tasks are escalated by
+func (s *Store) EscalationRules() []EscalationRule {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]EscalationRule{}, s.escalationRules...)
+}
+
+// Escalate applies the escalation rules to every open task as of now. Each task is
+// escalated by at most one rule per pass, and by each rule at most once while it
+// stays in the same state. It is called periodically, as tasks go stale without
+// any change to the store.
+func (s *Store) Escalate(now time.Time) error {
+	strategy := s.assignmentStrategy()
+
+	s.Lock()
+
+	// Open tasks, with the agent holding them (nil while waiting)
+	type openTask struct {
+		t     *Task
+		agent *Agent
+	}
+	open := []openTask{}
+	for _, t := range s.pendingTasks {
+		open = append(open, openTask{t, nil})
+	}
+	for _, a := range s.agents {
+		for _, t := range a.Tasks {
+			open = append(open, openTask{t, a})
+		}
+	}
+
+	escalated := 0
+	raisedToFront := []openTask{}
+	for _, o := range open {
+		for i := range s.escalationRules {
+			rule := &s.escalationRules[i]
+			if !s.escalationDue(rule, o.t, now) {
+				continue
+			}
+			wasFront := o.agent != nil && o.agent.Tasks[0] == o.t
+			ok, err := s.escalateTask(rule, o.t, o.agent, strategy, now)
+			if err != nil {
+				s.Unlock()
+				return errors.Wrapf(err, "Escalation rule %q, task %d", rule.Name, o.t.ID)
+			}
+			if ok {
+				escalated++
+				if o.agent != nil && !wasFront && len(o.agent.Tasks) > 0 && o.agent.Tasks[0] == o.t {
+					raisedToFront = append(raisedToFront, o)
+				}
+				break
+			}
+		}
+	}
+	s.Unlock()
+
+	// A held task raised to the front of its holder's queue displaces the rest, as a new one would
+	for _, o := range raisedToFront {
+		_, err := s.preemptTasks(o.agent.ID, o.t, strategy)
+		if err != nil {
+			log.Errorf("Store.Escalate() --> s.preemptTasks(%d): %v", o.agent.ID, errors.Cause(err))
+		}
+	}
+
+	// Raised tasks may now outrank an agent's queue, and rerouted tasks free their holders
+	if escalated > 0 {
+		s.assignPendingTasks()
+	}
+
+	return nil
+}
+
+// escalationDue reports whether the rule applies to the task as of now; callers must hold the lock
+func (s *Store) escalationDue(rule *EscalationRule, t *Task, now time.Time) bool {
+	if t.State != rule.State || (rule.Priority != "" && t.Priority != rule.Priority) {
+		return false
+	}
+
+	// The task entered its state at its latest event moving it there (or to another
+	// agent); raising its priority or rating its SLA leaves it in place
+	since := t.CreatedTime
+	events := s.history[t.ID]
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		if ev.Kind == TaskEventSLAChanged || ev.ToState != t.State {
+			continue
+		}
+		if ev.Kind == TaskEventEscalated && ev.FromState == ev.ToState && ev.FromAgentID == 0 {
+			continue
+		}
+		since = ev.Time
+		break
+	}
+	if since.IsZero() || now.Sub(since) < time.Duration(rule.AfterSeconds)*time.Second {
+		return false
+	}
+
+	// Each rule fires once per stay in the state
+	for _, ev := range events {
+		if ev.Kind == TaskEventEscalated && ev.Reason == rule.Name && !ev.Time.Before(since) {
+			return false
+		}
+	}
+	return true
+}
+
+// escalateTask applies the rule's action to the task, held by agent (nil while
+// waiting), reporting false if it could not be applied yet; callers must hold the lock
+func (s *Store) escalateTask(rule *EscalationRule, t *Task, agent *Agent, strategy AssignmentStrategy, now time.Time) (bool, error) {
+	ci := ChangeInfo{Actor: ActorSystem, Reason: rule.Name}
+	var holderID uint
+	if agent != nil {
+		holderID = agent.ID
+	}
+
+	switch rule.Action {
+	case EscalateRaisePriority:
+		to, ok := rule.raisedPriority(t)
+		if !ok {
+			return false, nil
+		}
+		raised := t.Clone()
+		raised.Priority = to
+
+		// An agent never holds two tasks of the same rank, so a held task that would join
+		// another goes back to the pending queue at its new priority, unless work on it has
+		// begun; then it waits until the other is out of the way
+		if agent != nil && agent.rivalTask(to, t.ID) != nil {
+			if t.State != TaskAssigned {
+				log.Tracef("Store.Escalate(): Agent (ID: %v) already holds a %v task; not raising task (ID: %v) yet", agent.ID, to, t.ID)
+				return false, nil
+			}
+			event := newTaskEvent(TaskEventEscalated, t, TaskQueued, 0, ci)
+			raised.AssignedAgent = nil
+			raised.AssignmentTime = time.Time{}
+			raised.State = TaskQueued
+			return true, s.commit(&JournalEntry{Op: OpReturnTask, Time: now, TaskID: t.ID, Task: &raised, Events: []TaskEvent{event}})
+		}
+
+		// Otherwise it moves up the pending queue, or its holder's queue
+		event := newTaskEvent(TaskEventEscalated, t, t.State, holderID, ci)
+		return true, s.commit(&JournalEntry{Op: OpSetTaskPriority, Time: now, TaskID: t.ID, Task: &raised, Events: []TaskEvent{event}})
+
+	case EscalateReroute:
+		pool := Agents{}
+		for _, id := range rule.PoolAgentIDs {
+			a := s.agentByID(id)
+			if a == nil || a == agent || a.Deactivated || !a.AvailableForAssignment(t.Priority) {
+				continue
+			}
+			if _, onShift := a.OnShiftUntil(now); !onShift {
+				continue
+			}
+			pool = append(pool, *a)
+		}
+		if len(pool) == 0 {
+			log.Tracef("Store.Escalate(): No agent in the pool of rule %q is available for task (ID: %v)", rule.Name, t.ID)
+			return false, nil
+		}
+		selected, err := strategy.SelectAgent(t, pool)
+		if err != nil {
+			return false, errors.Wrapf(err, "%s.SelectAgent()", strategy.Name())
+		}
+
+		event := newTaskEvent(TaskEventEscalated, t, TaskAssigned, selected.ID, ci)
+		event.FromAgentID = holderID
+		moved := t.Clone()
+		moved.AssignedAgent = nil
+		moved.AssignmentTime = now
+		if moved.FirstAssignedTime.IsZero() {
+			moved.FirstAssignedTime = now
+		}
+		moved.State = TaskAssigned
+		if agent != nil {
+			return true, s.commit(&JournalEntry{Op: OpReassignTask, Time: now, TaskID: t.ID, AgentID: selected.ID, Task: &moved, Events: []TaskEvent{event}})
+		}
+		// A waiting task joins the pool agent's queue ahead of anything less urgent they hold
+		return true, s.commit(&JournalEntry{Op: OpUnshiftTask, Time: now, TaskID: t.ID, AgentID: selected.ID, Task: &moved, Events: []TaskEvent{event}})
+	}
+
+	return false, nil
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	fileStoreSnapshotName = "snapshot.json"
+	fileStoreJournalName  = "journal.log"
+)
+
+// FileStore (file) is a Store whose mutations are appended to a write-ahead
+// journal on disk before being applied in memory. The journal is periodically
+// compacted into a snapshot; on open, the snapshot is loaded and the journal
+// replayed on top of it, restoring the exact state prior to a crash.
+type FileStore struct {
+	*Store
+
+	mu        sync.Mutex
+	dir       string
+	file      *os.File
+	seq       uint64 // Seq of the last entry written
+	unsnapped int    // # of entries written since the last snapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// fileStoreSnapshot is the on-disk representation of a Store's full state
+type fileStoreSnapshot struct {
+	Seq            uint64                    `json:"seq"`
+	Skills         []*SkillDefinition        `json:"skills"`
+	Agents         []*Agent                  `json:"agents"`
+	PendingTasks   []*Task                   `json:"pending_tasks"`
+	CompletedTasks []*Task                   `json:"completed_tasks"`
+	CancelledTasks []*Task                   `json:"cancelled_tasks,omitempty"`
+	RetiredAgentID uint                      `json:"retired_agent_id,omitempty"`
+	RetiredTaskID  uint                      `json:"retired_task_id,omitempty"`
+	History        map[uint][]TaskEvent      `json:"history,omitempty"`
+	PresenceLog    map[uint][]PresenceChange `json:"presence_log,omitempty"`
+}
+
+// Ensure FileStore satisfies Repository and Journal
+var _ Repository = (*FileStore)(nil)
+var _ Journal = (*FileStore)(nil)
+
+// OpenFileStore restores (or initializes) a store persisted in dir. If
+// snapshotInterval is > 0, the journal is compacted into a new snapshot on
+// that interval whenever it has grown.
+func OpenFileStore(dir string, snapshotInterval time.Duration) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll(dir)")
+	}
+
+	fs := &FileStore{
+		Store: &Store{},
+		dir:   dir,
+	}
+
+	// Restore the latest snapshot, if there is one
+	err = fs.loadSnapshot()
+	if err != nil {
+		return nil, errors.Wrap(err, "fs.loadSnapshot()")
+	}
+
+	// Replay the journal on top of it
+	fs.file, err = os.OpenFile(filepath.Join(dir, fileStoreJournalName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile(journal)")
+	}
+	err = fs.replay()
+	if err != nil {
+		fs.file.Close()
+		return nil, errors.Wrap(err, "fs.replay()")
+	}
+
+	// Only now that state is restored, start journaling new mutations
+	fs.Store.journal = fs
+
+	if snapshotInterval > 0 {
+		fs.stop = make(chan struct{})
+		fs.done = make(chan struct{})
+		go fs.snapshotLoop(snapshotInterval)
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStore) loadSnapshot() error {
+	data, err := ioutil.ReadFile(filepath.Join(fs.dir, fileStoreSnapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ioutil.ReadFile(snapshot)")
+	}
+
+	var snap fileStoreSnapshot
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return errors.Wrap(err, "json.Unmarshal(snapshot)")
+	}
+
+	fs.seq = snap.Seq
+	for _, sd := range snap.Skills {
+		fs.Store.putSkill(sd)
+	}
+	fs.Store.agents = snap.Agents
+	fs.Store.pendingTasks = snap.PendingTasks
+	fs.Store.completedTasks = snap.CompletedTasks
+	fs.Store.cancelledTasks = snap.CancelledTasks
+	fs.Store.retiredAgentID = snap.RetiredAgentID
+	fs.Store.retiredTaskID = snap.RetiredTaskID
+	fs.Store.history = snap.History
+	fs.Store.presenceLog = snap.PresenceLog
+
+	log.Tracef("FileStore: Restored snapshot at seq %d (%d agents, %d pending tasks, %d completed tasks)", snap.Seq, len(snap.Agents), len(snap.PendingTasks), len(snap.CompletedTasks))
+	return nil
+}
+
+// replay applies every journal entry newer than the snapshot. A torn final
+// line (a crash mid-write) is discarded, and an entry that does not apply is
+// skipped; corruption anywhere else is an error.
+func (fs *FileStore) replay() error {
+	reader := bufio.NewReader(fs.file)
+	offset := int64(0)
+	replayed, skipped := 0, 0
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Warnf("FileStore: Discarding incomplete journal entry at offset %d", offset)
+				err = fs.file.Truncate(offset)
+				if err != nil {
+					return errors.Wrap(err, "fs.file.Truncate(offset)")
+				}
+			}
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "reader.ReadBytes()")
+		}
+
+		var e JournalEntry
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return fmt.Errorf("Corrupt journal entry at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+
+		if e.Seq <= fs.seq {
+			// Already captured by the snapshot
+			continue
+		}
+		// An entry that no longer applies (e.g. journaled before entries were checked)
+		// is skipped, so that one bad entry cannot keep the store from opening
+		err = fs.Store.apply(&e)
+		if err != nil {
+			log.Errorf("FileStore: Skipping journal entry (seq %d, %s) that does not apply: %v", e.Seq, e.Op, err)
+			skipped++
+		} else {
+			replayed++
+		}
+		fs.seq = e.Seq
+		fs.unsnapped++
+	}
+
+	// Position for appending
+	_, err := fs.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "fs.file.Seek(offset)")
+	}
+
+	log.Tracef("FileStore: Replayed %d journal entries (%d skipped)", replayed, skipped)
+	return nil
+}
+
+// Append durably writes the entry to the journal; it is called by the Store with its write lock held
+func (fs *FileStore) Append(e *JournalEntry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	e.Seq = fs.seq + 1
+	line, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(entry)")
+	}
+	line = append(line, '\n')
+
+	_, err = fs.file.Write(line)
+	if err != nil {
+		return errors.Wrap(err, "fs.file.Write(entry)")
+	}
+	err = fs.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "fs.file.Sync()")
+	}
+
+	fs.seq = e.Seq
+	fs.unsnapped++
+	return nil
+}
+
+// Snapshot writes the full state of the store to disk and truncates the journal
+func (fs *FileStore) Snapshot() error {
+	// Holding the read lock excludes mutations (and therefore appends)
+	fs.Store.RLock()
+	defer fs.Store.RUnlock()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	skills := make([]*SkillDefinition, 0, len(fs.Store.skills))
+	for _, sd := range fs.Store.skills {
+		skills = append(skills, sd)
+	}
+
+	data, err := json.Marshal(fileStoreSnapshot{
+		Seq:            fs.seq,
+		Skills:         skills,
+		Agents:         fs.Store.agents,
+		PendingTasks:   fs.Store.pendingTasks,
+		CompletedTasks: fs.Store.completedTasks,
+		CancelledTasks: fs.Store.cancelledTasks,
+		RetiredAgentID: fs.Store.retiredAgentID,
+		RetiredTaskID:  fs.Store.retiredTaskID,
+		History:        fs.Store.history,
+		PresenceLog:    fs.Store.presenceLog,
+	})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal(snapshot)")
+	}
+
+	// Write-then-rename so a crash never leaves a partial snapshot behind
+	tmpPath := filepath.Join(fs.dir, fileStoreSnapshotName+".tmp")
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "os.Create(tmp)")
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing snapshot")
+	}
+	err = os.Rename(tmpPath, filepath.Join(fs.dir, fileStoreSnapshotName))
+	if err != nil {
+		return errors.Wrap(err, "os.Rename(snapshot)")
+	}
+
+	// Every journal entry is now captured by the snapshot
+	err = fs.file.Truncate(0)
+	if err != nil {
+		return errors.Wrap(err, "fs.file.Truncate(0)")
+	}
+	_, err = fs.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "fs.file.Seek(0)")
+	}
+
+	fs.unsnapped = 0
+	log.Tracef("FileStore: Wrote snapshot at seq %d", fs.seq)
+	return nil
+}
+
+func (fs *FileStore) snapshotLoop(interval time.Duration) {
+	defer close(fs.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.stop:
+			return
+		case <-ticker.C:
+			fs.mu.Lock()
+			pending := fs.unsnapped
+			fs.mu.Unlock()
+			if pending == 0 {
+				continue
+			}
+			err := fs.Snapshot()
+			if err != nil {
+				log.Errorf("FileStore: Periodic snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// Close takes a final snapshot and releases the journal file
+func (fs *FileStore) Close() error {
+	if fs.stop != nil {
+		close(fs.stop)
+		<-fs.done
+	}
+
+	err := fs.Snapshot()
+	if err != nil {
+		return errors.Wrap(err, "fs.Snapshot()")
+	}
+
+	return fs.file.Close()
+}
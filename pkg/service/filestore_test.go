@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileStore_Recovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffn-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Build up some state: 3 skills, 3 agents, 3 tasks, 1 of which is completed
+	fs, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sd := range BuildSeedSkills() {
+		err = fs.AddSkill(*sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = fs.AddAgents(BuildSeedAgents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range []*Task{
+		&Task{Priority: PriorityLow, ReqSkills: Skills{Skill1}},
+		&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill1}},
+		&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill3}},
+	} {
+		_, _, err = fs.AddTaskToAgent(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = fs.MarkAsCompleted(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.SetAgentPresence(2, PresenceOffline, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantAgents, _ := fs.ListAgents()
+	wantCompleted, _ := fs.ListCompletedTasks()
+
+	// Simulate a crash: abandon the store without a final snapshot
+	fs.file.Close()
+
+	// Journal only
+	restored, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotAgents, _ := restored.ListAgents()
+	gotCompleted, _ := restored.ListCompletedTasks()
+	assertSameAgents(t, wantAgents, gotAgents)
+	assert.Equal(t, len(wantCompleted), len(gotCompleted))
+	assert.Equal(t, wantCompleted[0].ID, gotCompleted[0].ID)
+
+	// Snapshot + journal
+	err = restored.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restored.MarkAsCompleted(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAgents, _ = restored.ListAgents()
+	restored.file.Close()
+
+	restoredAgain, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredAgain.Close()
+	gotAgents, _ = restoredAgain.ListAgents()
+	gotCompleted, _ = restoredAgain.ListCompletedTasks()
+	assertSameAgents(t, wantAgents, gotAgents)
+	assert.Equal(t, 2, len(gotCompleted))
+	gotSkills, _ := restoredAgain.ListSkills()
+	assert.Equal(t, 3, len(gotSkills))
+	assert.Equal(t, uint(4), restoredAgain.NextTaskID())
+
+	// A deleted task's ID is never reissued, even once it is the highest
+	_, taskID, err := restoredAgain.AddTaskToAgent(&Task{Priority: PriorityLow, ReqSkills: Skills{Skill1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restoredAgain.DeleteTask(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredAgain.file.Close()
+	restoredDeleted, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredDeleted.Close()
+	assert.Equal(t, uint(5), restoredDeleted.NextTaskID())
+	assertHistoryKinds(t, restoredAgain.Store, 1, TaskEventCreated, TaskEventAssigned, TaskEventStateChanged)
+	changes, err := restoredAgain.PresenceLog(2)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(changes)) {
+		assert.Equal(t, PresenceOffline, changes[0].To)
+		assert.False(t, changes[0].Time.IsZero())
+	}
+}
+
+func Test_FileStore_EntryThatDoesNotApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffn-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sd := range BuildSeedSkills() {
+		err = fs.AddSkill(*sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = fs.AddAgents([]*Agent{&Agent{Name: "Adam", Skills: Skills{Skill1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry for an agent that does not exist (e.g. deleted concurrently) is refused before it is journaled
+	seq := fs.seq
+	fs.Store.Lock()
+	err = fs.Store.commit(&JournalEntry{Op: OpPushTask, AgentID: 2, Task: &Task{ID: 1, Priority: PriorityHigh, ReqSkills: Skills{Skill1}}})
+	fs.Store.Unlock()
+	assert.Equal(t, ErrAgentNotFound, err)
+	assert.Equal(t, seq, fs.seq)
+
+	// One already in the journal (written before entries were checked) is skipped on replay
+	line, err := json.Marshal(&JournalEntry{Seq: seq + 1, Op: OpPushTask, AgentID: 2, Task: &Task{ID: 1, Priority: PriorityHigh, ReqSkills: Skills{Skill1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.file.Write(append(line, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.seq++
+	_, _, err = fs.AddTaskToAgent(&Task{Priority: PriorityHigh, ReqSkills: Skills{Skill1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.file.Close()
+
+	restored, err := OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	task, err := restored.FindTaskWithAgent(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Adam", task.AssignedAgent.Name)
+	}
+	agents, _ := restored.ListAgents()
+	assert.Equal(t, 1, len(agents))
+}
+
+// assertHistoryKinds checks the sequence of events recorded for a task
+func assertHistoryKinds(t *testing.T, s *Store, taskID uint, want ...TaskEventKind) {
+	t.Helper()
+	events, err := s.TaskHistory(taskID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	got := []TaskEventKind{}
+	for _, ev := range events {
+		got = append(got, ev.Kind)
+	}
+	assert.Equal(t, want, got)
+}
+
+// assertSameAgents compares agents' queues, comparing timestamps with Equal as they
+// lose their monotonic clock readings in a JSON round trip
+func assertSameAgents(t *testing.T, want, got []*Agent) {
+	t.Helper()
+	if !assert.Equal(t, len(want), len(got)) {
+		return
+	}
+	for i := range want {
+		assert.Equal(t, want[i].ID, got[i].ID)
+		assert.Equal(t, want[i].Skills, got[i].Skills)
+		assert.Equal(t, want[i].Deactivated, got[i].Deactivated)
+		assert.Equal(t, want[i].Capacity, got[i].Capacity)
+		assert.Equal(t, want[i].Presence, got[i].Presence)
+		assert.Equal(t, want[i].Schedule, got[i].Schedule)
+		if !assert.Equal(t, len(want[i].Tasks), len(got[i].Tasks)) {
+			continue
+		}
+		for j := range want[i].Tasks {
+			w, g := want[i].Tasks[j], got[i].Tasks[j]
+			assert.Equal(t, w.ID, g.ID)
+			assert.Equal(t, w.Priority, g.Priority)
+			assert.Equal(t, w.State, g.State)
+			assert.True(t, w.AssignmentTime.Equal(g.AssignmentTime))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"time"
+)
+
+// ActorSystem is the actor recorded for changes the engine makes on its own,
+// e.g. assigning a waiting task when an agent frees up
+const ActorSystem = "system"
+
+// ChangeInfo attributes a change to whoever asked for it, and why
+type ChangeInfo struct {
+	Actor  string `json:"actor,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TaskEventKind identifies what happened to a task
+type TaskEventKind string
+
+const (
+	TaskEventCreated      TaskEventKind = "created"
+	TaskEventAssigned     TaskEventKind = "assigned"
+	TaskEventReassigned   TaskEventKind = "reassigned"
+	TaskEventStateChanged TaskEventKind = "state_changed"
+	TaskEventReturned     TaskEventKind = "returned" // Taken from its agent and put back in the pending queue
+	TaskEventReopened     TaskEventKind = "reopened"
+	TaskEventDeleted      TaskEventKind = "deleted"
+	TaskEventSLAChanged   TaskEventKind = "sla_changed" // Its SLA status changed; the new status is the reason
+	TaskEventEscalated    TaskEventKind = "escalated"   // Raised in priority or rerouted by an escalation rule, named in the reason
+)
+
+// TaskEvent is a single entry in a task's append-only history
+type TaskEvent struct {
+	TaskID    uint          `json:"task_id"`
+	Time      time.Time     `json:"time"`
+	Kind      TaskEventKind `json:"event"`
+	FromState TaskState     `json:"from_state"`
+	ToState   TaskState     `json:"to_state"`
+
+	// AgentID is the agent holding the task after the event, if any
+	AgentID uint `json:"agent_id,omitempty"`
+	// FromAgentID is the agent that held the task before a reassignment
+	FromAgentID uint `json:"from_agent_id,omitempty"`
+
+	Actor  string `json:"actor,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// newTaskEvent builds an event for the task moving to state to; the time is filled in on commit
+func newTaskEvent(kind TaskEventKind, t *Task, to TaskState, agentID uint, ci ChangeInfo) TaskEvent {
+	return TaskEvent{
+		TaskID:    t.ID,
+		Kind:      kind,
+		FromState: t.State,
+		ToState:   to,
+		AgentID:   agentID,
+		Actor:     ci.Actor,
+		Reason:    ci.Reason,
+	}
+}
+
+// TaskHistory returns every recorded event for the task, oldest first
+func (s *Store) TaskHistory(taskID uint) ([]TaskEvent, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	events, ok := s.history[taskID]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	return append([]TaskEvent{}, events...), nil
+}
+
+// recordEvents appends events to their tasks' histories; callers must hold the lock
+func (s *Store) recordEvents(events []TaskEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if s.history == nil {
+		s.history = map[uint][]TaskEvent{}
+	}
+	for _, ev := range events {
+		s.history[ev.TaskID] = append(s.history[ev.TaskID], ev)
+	}
+}
+
+// TESTING_resetHistory is for testing purposes; discards all task history, so
+// that stores can be compared without spelling out every event
+func (s *Store) TESTING_resetHistory() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.history = nil
+}
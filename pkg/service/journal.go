@@ -0,0 +1,437 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JournalOp identifies the kind of mutation recorded by a JournalEntry
+type JournalOp string
+
+const (
+	OpAddAgent     JournalOp = "add_agent"
+	OpPushTask     JournalOp = "push_task"
+	OpUnshiftTask  JournalOp = "unshift_task"
+	OpCompleteTask JournalOp = "complete_task"
+	OpDeleteTask   JournalOp = "delete_task"
+	OpEnqueueTask  JournalOp = "enqueue_task"
+	OpPutSkill     JournalOp = "put_skill"
+	OpDeleteSkill  JournalOp = "delete_skill"
+
+	OpSetAgentSkills   JournalOp = "set_agent_skills"
+	OpSetAgentCapacity JournalOp = "set_agent_capacity"
+	OpSetAgentPresence JournalOp = "set_agent_presence"
+	OpSetAgentSchedule JournalOp = "set_agent_schedule"
+	OpDeactivateAgent  JournalOp = "deactivate_agent"
+	OpActivateAgent    JournalOp = "activate_agent"
+	OpDeleteAgent      JournalOp = "delete_agent"
+
+	OpSetTaskState    JournalOp = "set_task_state"
+	OpReopenTask      JournalOp = "reopen_task"
+	OpReassignTask    JournalOp = "reassign_task"
+	OpReturnTask      JournalOp = "return_task"
+	OpCancelTask      JournalOp = "cancel_task"
+	OpSetTaskSLA      JournalOp = "set_task_sla"
+	OpSetTaskPriority JournalOp = "set_task_priority"
+)
+
+// JournalEntry is a single, replayable mutation of a Store. Entries carry the
+// outcome of a mutation (e.g. the agent a task was assigned to), never the
+// inputs to a decision, so replaying them always reproduces the same state.
+type JournalEntry struct {
+	Seq     uint64    `json:"seq"`
+	Op      JournalOp `json:"op"`
+	Time    time.Time `json:"time"`
+	AgentID uint      `json:"agent_id,omitempty"`
+	TaskID  uint      `json:"task_id,omitempty"`
+	Agent   *Agent    `json:"agent,omitempty"`
+	Task    *Task     `json:"task,omitempty"`
+
+	Skill *SkillDefinition `json:"skill,omitempty"`
+
+	// Presence is appended to its agent's presence log
+	Presence *PresenceChange `json:"presence,omitempty"`
+
+	// Events are appended to the affected tasks' histories
+	Events []TaskEvent `json:"events,omitempty"`
+}
+
+// Journal receives every Store mutation before it is applied
+type Journal interface {
+	Append(e *JournalEntry) error
+}
+
+// commit checks the entry against the current state, records it in the journal (if any),
+// then applies it; callers must hold the write lock
+func (s *Store) commit(e *JournalEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for i := range e.Events {
+		if e.Events[i].Time.IsZero() {
+			e.Events[i].Time = e.Time
+		}
+	}
+	if e.Presence != nil && e.Presence.Time.IsZero() {
+		e.Presence.Time = e.Time
+	}
+
+	// A journaled entry must apply cleanly, or it would fail again on every replay
+	err := s.check(e)
+	if err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		err = s.journal.Append(e)
+		if err != nil {
+			return errors.Wrap(err, "s.journal.Append()")
+		}
+	}
+
+	return s.apply(e)
+}
+
+// check reports whether the entry would apply to the current state, without changing
+// it: that its payload is present and the agents and tasks it names exist; callers
+// must hold the lock
+func (s *Store) check(e *JournalEntry) error {
+	needTask := func() error {
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		return nil
+	}
+	needAgent := func() error {
+		if s.agentByID(e.AgentID) == nil {
+			return ErrAgentNotFound
+		}
+		return nil
+	}
+	needHeldTask := func() error {
+		if _, t := s.heldTaskByID(e.TaskID); t == nil {
+			return ErrTaskNotFound
+		}
+		return nil
+	}
+	needOpenTask := func() error {
+		if _, t := s.heldTaskByID(e.TaskID); t == nil && s.pendingTaskByID(e.TaskID) == nil {
+			return ErrTaskNotFound
+		}
+		return nil
+	}
+
+	switch e.Op {
+	case OpAddAgent:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+
+	case OpSetAgentSkills, OpSetAgentCapacity, OpSetAgentSchedule:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+		return needAgent()
+
+	case OpSetAgentPresence:
+		if e.Presence == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no presence", e.Seq, e.Op)
+		}
+		return needAgent()
+
+	case OpDeactivateAgent, OpDeleteAgent, OpActivateAgent:
+		return needAgent()
+
+	case OpPushTask, OpUnshiftTask:
+		if err := needTask(); err != nil {
+			return err
+		}
+		return needAgent()
+
+	case OpReassignTask:
+		if err := needTask(); err != nil {
+			return err
+		}
+		if err := needAgent(); err != nil {
+			return err
+		}
+		return needHeldTask()
+
+	case OpReturnTask, OpCompleteTask, OpSetTaskState:
+		if err := needTask(); err != nil {
+			return err
+		}
+		return needHeldTask()
+
+	case OpDeleteTask:
+		return needHeldTask()
+
+	case OpCancelTask, OpSetTaskSLA:
+		if err := needTask(); err != nil {
+			return err
+		}
+		return needOpenTask()
+
+	case OpSetTaskPriority:
+		if err := needTask(); err != nil {
+			return err
+		}
+		if err := needOpenTask(); err != nil {
+			return err
+		}
+		// An agent never holds two tasks of the same rank
+		if agent, _ := s.heldTaskByID(e.TaskID); agent != nil && agent.rivalTask(e.Task.Priority, e.TaskID) != nil {
+			return fmt.Errorf("Agent %d already holds a %v task", agent.ID, e.Task.Priority)
+		}
+		return nil
+
+	case OpReopenTask:
+		if err := needTask(); err != nil {
+			return err
+		}
+		if s.finishedTaskByID(e.TaskID) == nil {
+			return ErrTaskNotFound
+		}
+
+	case OpEnqueueTask:
+		return needTask()
+
+	case OpPutSkill, OpDeleteSkill:
+		if e.Skill == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no skill", e.Seq, e.Op)
+		}
+
+	default:
+		return fmt.Errorf("Unknown journal op: %v", e.Op)
+	}
+
+	return nil
+}
+
+// apply performs the mutation described by the entry; callers must hold the write lock
+func (s *Store) apply(e *JournalEntry) error {
+	switch e.Op {
+	case OpAddAgent:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+		s.agents = append(s.agents, e.Agent)
+
+	case OpSetAgentSkills:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		agent.Skills = e.Agent.Skills
+		agent.SkillLevels = e.Agent.SkillLevels
+
+	case OpSetAgentCapacity:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		agent.Capacity = e.Agent.Capacity
+
+	case OpSetAgentSchedule:
+		if e.Agent == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no agent", e.Seq, e.Op)
+		}
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		agent.Schedule = e.Agent.Schedule
+
+	case OpSetAgentPresence:
+		if e.Presence == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no presence", e.Seq, e.Op)
+		}
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		agent.Presence = e.Presence.To
+		s.recordPresenceChange(*e.Presence)
+
+	case OpDeactivateAgent, OpDeleteAgent:
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		s.returnAgentTasks(agent, e.Time)
+		if e.Op == OpDeleteAgent {
+			s.removeAgent(e.AgentID)
+		} else {
+			agent.Deactivated = true
+		}
+
+	case OpActivateAgent:
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return ErrAgentNotFound
+		}
+		agent.Deactivated = false
+
+	case OpPushTask, OpUnshiftTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		agent := s.agentByID(e.AgentID)
+		if agent == nil {
+			return fmt.Errorf("Agent not found")
+		}
+		if e.Op == OpPushTask {
+			agent.Tasks = append(agent.Tasks, e.Task)
+		} else {
+			agent.Tasks = append([]*Task{e.Task}, agent.Tasks...)
+		}
+		// Queues stay in priority order, as agents with a capacity limit stack tasks of any priority
+		agent.Tasks = moveAheadOfLessUrgent(agent.Tasks, e.Task)
+		// Assigning a waiting task takes it off the pending queue
+		s.removePendingTask(e.Task.ID)
+
+	case OpReassignTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		to := s.agentByID(e.AgentID)
+		if to == nil {
+			return ErrAgentNotFound
+		}
+		from, err := s.removeTask(e.TaskID)
+		if err != nil {
+			return err
+		}
+		from.markIdleIfEmpty(e.Time)
+		to.Tasks = moveAheadOfLessUrgent(append([]*Task{e.Task}, to.Tasks...), e.Task)
+
+	case OpReturnTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		from, err := s.removeTask(e.TaskID)
+		if err != nil {
+			return err
+		}
+		from.markIdleIfEmpty(e.Time)
+		s.insertPendingTask(e.Task)
+
+	case OpCompleteTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		agent, err := s.removeTask(e.TaskID)
+		if err != nil {
+			return err
+		}
+		s.completedTasks = append(s.completedTasks, e.Task)
+		agent.markIdleIfEmpty(e.Time)
+
+	case OpCancelTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		// The task is either held by an agent or still waiting
+		if agent, err := s.removeTask(e.TaskID); err == nil {
+			agent.markIdleIfEmpty(e.Time)
+		} else if !s.removePendingTask(e.TaskID) {
+			return ErrTaskNotFound
+		}
+		s.cancelledTasks = append(s.cancelledTasks, e.Task)
+
+	case OpDeleteTask:
+		agent, err := s.removeTask(e.TaskID)
+		if err != nil {
+			return err
+		}
+		agent.markIdleIfEmpty(e.Time)
+		if e.TaskID > s.retiredTaskID {
+			s.retiredTaskID = e.TaskID
+		}
+
+	case OpSetTaskState:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		_, t := s.heldTaskByID(e.TaskID)
+		if t == nil {
+			return ErrTaskNotFound
+		}
+		t.State = e.Task.State
+
+	case OpSetTaskSLA:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		// The task is either held by an agent or still waiting
+		_, t := s.heldTaskByID(e.TaskID)
+		if t == nil {
+			t = s.pendingTaskByID(e.TaskID)
+		}
+		if t == nil {
+			return ErrTaskNotFound
+		}
+		t.SLAStatus = e.Task.SLAStatus
+
+	case OpSetTaskPriority:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		// A held task moves up its holder's queue
+		if agent, t := s.heldTaskByID(e.TaskID); t != nil {
+			t.Priority = e.Task.Priority
+			agent.Tasks = moveAheadOfLessUrgent(agent.Tasks, t)
+			break
+		}
+		// A waiting task moves up the pending queue
+		t := s.pendingTaskByID(e.TaskID)
+		if t == nil {
+			return ErrTaskNotFound
+		}
+		s.removePendingTask(e.TaskID)
+		t.Priority = e.Task.Priority
+		s.insertPendingTask(t)
+
+	case OpReopenTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		if !s.removeCompletedTask(e.TaskID) && !s.removeCancelledTask(e.TaskID) {
+			return ErrTaskNotFound
+		}
+		s.insertPendingTask(e.Task)
+
+	case OpEnqueueTask:
+		if e.Task == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no task", e.Seq, e.Op)
+		}
+		s.insertPendingTask(e.Task)
+
+	case OpPutSkill:
+		if e.Skill == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no skill", e.Seq, e.Op)
+		}
+		s.putSkill(e.Skill)
+
+	case OpDeleteSkill:
+		if e.Skill == nil {
+			return fmt.Errorf("Journal entry %d (%s) has no skill", e.Seq, e.Op)
+		}
+		delete(s.skills, e.Skill.Name)
+
+	default:
+		return fmt.Errorf("Unknown journal op: %v", e.Op)
+	}
+
+	s.recordEvents(e.Events)
+
+	return nil
+}
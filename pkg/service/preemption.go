@@ -0,0 +1,127 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidPreemptionPolicy = fmt.Errorf("Invalid preemption policy")
+
+// PreemptionPolicy decides what happens to the tasks an agent has not yet started
+// when a higher-priority task is put in front of them
+type PreemptionPolicy string
+
+const (
+	// PreemptKeep leaves the displaced tasks queued behind the new one
+	PreemptKeep PreemptionPolicy = "keep"
+	// PreemptReassign offers each displaced task to another available agent, keeping it if there is none
+	PreemptReassign PreemptionPolicy = "reassign"
+	// PreemptReturn returns the displaced tasks to the pending queue, keeping their original place
+	PreemptReturn PreemptionPolicy = "return"
+)
+
+// ParsePreemptionPolicy returns the named policy; an empty name is PreemptKeep
+func ParsePreemptionPolicy(name string) (PreemptionPolicy, error) {
+	switch PreemptionPolicy(name) {
+	case "", PreemptKeep:
+		return PreemptKeep, nil
+	case PreemptReassign:
+		return PreemptReassign, nil
+	case PreemptReturn:
+		return PreemptReturn, nil
+	}
+	return "", errors.Wrapf(ErrInvalidPreemptionPolicy, "%q (expected %q, %q or %q)", name, PreemptKeep, PreemptReassign, PreemptReturn)
+}
+
+// SetPreemptionPolicy sets what happens to the tasks an agent has not yet started when a
+// higher-priority task is put in front of them
+func (s *Store) SetPreemptionPolicy(policy PreemptionPolicy) error {
+	policy, err := ParsePreemptionPolicy(string(policy))
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.preemption = policy
+	return nil
+}
+
+// preemptionPolicy returns the current SetPreemptionPolicy setting
+func (s *Store) preemptionPolicy() PreemptionPolicy {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.preemption == "" {
+		return PreemptKeep
+	}
+	return s.preemption
+}
+
+// preemptTasks applies the preemption policy to the tasks the agent has not yet started,
+// now that the given task has been put in front of them, reporting how many were
+// returned to the pending queue. Work the agent has begun stays with them, and
+// tasks handed to another agent never displace that agent's own tasks in turn.
+func (s *Store) preemptTasks(agentID uint, by *Task, strategy AssignmentStrategy) (returned int, err error) {
+	policy := s.preemptionPolicy()
+	if policy == PreemptKeep {
+		return 0, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		return 0, ErrAgentNotFound
+	}
+	displaced := []*Task{}
+	for _, t := range agent.Tasks {
+		if t.ID != by.ID && t.State == TaskAssigned {
+			displaced = append(displaced, t)
+		}
+	}
+
+	ci := ChangeInfo{Actor: ActorSystem, Reason: fmt.Sprintf("Preempted by task %d", by.ID)}
+	for _, t := range displaced {
+		now := time.Now()
+
+		switch policy {
+		case PreemptReassign:
+			to, err := s.selectAgentExcluding(t, strategy, agentID)
+			if err == ErrNoSkilledAgents || err == ErrNoAvailableAgents {
+				continue
+			}
+			if err != nil {
+				return returned, err
+			}
+
+			event := newTaskEvent(TaskEventReassigned, t, TaskAssigned, to.ID, ci)
+			event.FromAgentID = agentID
+			moved := t.Clone()
+			moved.AssignedAgent = nil
+			moved.AssignmentTime = now
+			err = s.commit(&JournalEntry{Op: OpReassignTask, Time: now, TaskID: t.ID, AgentID: to.ID, Task: &moved, Events: []TaskEvent{event}})
+			if err != nil {
+				return returned, err
+			}
+
+		case PreemptReturn:
+			event := newTaskEvent(TaskEventReturned, t, TaskQueued, 0, ci)
+			waiting := t.Clone()
+			waiting.AssignedAgent = nil
+			waiting.AssignmentTime = time.Time{}
+			waiting.State = TaskQueued
+			err = s.commit(&JournalEntry{Op: OpReturnTask, Time: now, TaskID: t.ID, Task: &waiting, Events: []TaskEvent{event}})
+			if err != nil {
+				return returned, err
+			}
+			returned++
+		}
+	}
+
+	return returned, nil
+}
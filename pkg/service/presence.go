@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Presence is whether an agent is at their desk; only online agents are assigned tasks.
+// The zero value is online, so agents are assignable unless they say otherwise.
+type Presence int
+
+const (
+	PresenceOnline  Presence = iota // 0 - online: available for assignment
+	PresenceAway                    // 1 - away: briefly unavailable (e.g. at lunch), keeping their tasks
+	PresenceOffline                 // 2 - offline: logged out, keeping their tasks
+)
+
+var presenceNames = map[Presence]string{
+	PresenceOnline:  "online",
+	PresenceAway:    "away",
+	PresenceOffline: "offline",
+}
+
+func (p Presence) String() string {
+	if name, ok := presenceNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Presence(%d)", int(p))
+}
+
+// ParsePresence returns the presence with the given name, e.g. "away"
+func ParsePresence(name string) (Presence, error) {
+	for p, n := range presenceNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid Presence: %v (expected online, away or offline)", name)
+}
+
+// MarshalText encodes the presence by name, e.g. "away"
+func (p Presence) MarshalText() ([]byte, error) {
+	if _, ok := presenceNames[p]; !ok {
+		return nil, fmt.Errorf("Invalid Presence: %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+func (p *Presence) UnmarshalText(text []byte) error {
+	parsed, err := ParsePresence(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// PresenceChange is a single entry in an agent's presence log
+type PresenceChange struct {
+	AgentID uint      `json:"agent_id"`
+	Time    time.Time `json:"time"`
+	From    Presence  `json:"from"`
+	To      Presence  `json:"to"`
+	Actor   string    `json:"actor,omitempty"`
+}
+
+// SetAgentPresence records the agent as online, away or offline. Agents who are
+// not online keep the tasks they hold, but are not assigned any more.
+func (s *Store) SetAgentPresence(agentID uint, p Presence, actor string) error {
+	if _, ok := presenceNames[p]; !ok {
+		return fmt.Errorf("Invalid Presence: %d", int(p))
+	}
+
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+	if agent.Presence == p {
+		s.Unlock()
+		return nil
+	}
+
+	change := &PresenceChange{AgentID: agentID, From: agent.Presence, To: p, Actor: actor}
+	err := s.commit(&JournalEntry{Op: OpSetAgentPresence, AgentID: agentID, Presence: change})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// An agent coming online may take waiting tasks
+	if p == PresenceOnline {
+		s.assignPendingTasks()
+	}
+
+	return nil
+}
+
+// PresenceLog returns every recorded presence change for the agent, oldest first
+func (s *Store) PresenceLog(agentID uint) ([]PresenceChange, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.agentByID(agentID) == nil && len(s.presenceLog[agentID]) == 0 {
+		return nil, ErrAgentNotFound
+	}
+
+	return append([]PresenceChange{}, s.presenceLog[agentID]...), nil
+}
+
+// recordPresenceChange appends a change to its agent's presence log; callers must hold the lock
+func (s *Store) recordPresenceChange(pc PresenceChange) {
+	if s.presenceLog == nil {
+		s.presenceLog = map[uint][]PresenceChange{}
+	}
+	s.presenceLog[pc.AgentID] = append(s.presenceLog[pc.AgentID], pc)
+}
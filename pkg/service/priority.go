@@ -2,6 +2,9 @@ package service
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type Priority string
@@ -11,12 +14,125 @@ const (
 	PriorityLow  Priority = "low"
 )
 
+// PriorityLevel is a configured priority; a higher rank is more urgent
+type PriorityLevel struct {
+	Name Priority `json:"name"`
+	Rank int      `json:"rank"`
+}
+
+// priorities is the registry of configured priority levels
+var priorities = struct {
+	sync.RWMutex
+	levels []PriorityLevel // Ordered most urgent first
+	ranks  map[Priority]int
+}{}
+
+func init() {
+	err := ConfigurePriorities(DefaultPriorityLevels())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// DefaultPriorityLevels returns the built-in levels: high outranks low
+func DefaultPriorityLevels() []PriorityLevel {
+	return []PriorityLevel{
+		{Name: PriorityHigh, Rank: 2},
+		{Name: PriorityLow, Rank: 1},
+	}
+}
+
+// ParsePriorityLevels builds levels from a comma-separated list of names,
+// most urgent first, e.g. "urgent,high,normal,low"
+func ParsePriorityLevels(list string) ([]PriorityLevel, error) {
+	names := strings.Split(list, ",")
+	levels := make([]PriorityLevel, 0, len(names))
+	for i, name := range names {
+		levels = append(levels, PriorityLevel{
+			Name: Priority(strings.TrimSpace(name)),
+			Rank: len(names) - i,
+		})
+	}
+
+	return levels, validatePriorityLevels(levels)
+}
+
+// ConfigurePriorities replaces the registry of valid priorities
+func ConfigurePriorities(levels []PriorityLevel) error {
+	err := validatePriorityLevels(levels)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]PriorityLevel, len(levels))
+	copy(sorted, levels)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rank > sorted[j].Rank
+	})
+	ranks := make(map[Priority]int, len(sorted))
+	for _, l := range sorted {
+		ranks[l.Name] = l.Rank
+	}
+
+	priorities.Lock()
+	defer priorities.Unlock()
+
+	priorities.levels = sorted
+	priorities.ranks = ranks
+	return nil
+}
+
+func validatePriorityLevels(levels []PriorityLevel) error {
+	if len(levels) < 1 {
+		return fmt.Errorf("At least one Priority level is required")
+	}
+
+	seenNames := map[Priority]bool{}
+	seenRanks := map[int]bool{}
+	for _, l := range levels {
+		if strings.TrimSpace(string(l.Name)) == "" {
+			return fmt.Errorf("Priority level name is required")
+		}
+		if seenNames[l.Name] {
+			return fmt.Errorf("Duplicate Priority level: %v", l.Name)
+		}
+		if seenRanks[l.Rank] {
+			return fmt.Errorf("Duplicate Priority rank: %v", l.Rank)
+		}
+		seenNames[l.Name] = true
+		seenRanks[l.Rank] = true
+	}
+	return nil
+}
+
+// PriorityLevels returns the configured levels, most urgent first
+func PriorityLevels() []PriorityLevel {
+	priorities.RLock()
+	defer priorities.RUnlock()
+
+	levels := make([]PriorityLevel, len(priorities.levels))
+	copy(levels, priorities.levels)
+	return levels
+}
+
 func (p *Priority) IsValid() error {
 	if string(*p) == "" {
 		return fmt.Errorf("Priority is required")
 	}
-	if *p != PriorityHigh && *p != PriorityLow {
+
+	priorities.RLock()
+	defer priorities.RUnlock()
+
+	if _, ok := priorities.ranks[*p]; !ok {
 		return fmt.Errorf("Invalid Priority: %v", *p)
 	}
 	return nil
 }
+
+// Rank returns the configured rank of the priority, or 0 if it is not configured
+func (p Priority) Rank() int {
+	priorities.RLock()
+	defer priorities.RUnlock()
+
+	return priorities.ranks[p]
+}
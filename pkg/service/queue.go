@@ -0,0 +1,125 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// enqueueTask parks a task that could not be assigned in the pending queue
+func (s *Store) enqueueTask(t *Task, ci ChangeInfo) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if t.ID == 0 {
+		t.ID = s.NextTaskID()
+	}
+	event := newTaskEvent(TaskEventCreated, t, TaskQueued, 0, ci)
+	t.State = TaskQueued
+	return s.commit(&JournalEntry{Op: OpEnqueueTask, Task: t, Events: []TaskEvent{event}})
+}
+
+// insertPendingTask adds a task to the pending queue, keeping it ordered by
+// priority (highest first) and then arrival; callers must hold the lock
+func (s *Store) insertPendingTask(t *Task) {
+	i := sort.Search(len(s.pendingTasks), func(i int) bool {
+		p := s.pendingTasks[i]
+		if p.Priority.Rank() != t.Priority.Rank() {
+			return p.Priority.Rank() < t.Priority.Rank()
+		}
+		return p.CreatedTime.After(t.CreatedTime)
+	})
+	s.pendingTasks = append(s.pendingTasks, nil)
+	copy(s.pendingTasks[i+1:], s.pendingTasks[i:])
+	s.pendingTasks[i] = t
+}
+
+// isPendingTask reports whether the task is waiting in the pending queue; callers must hold the lock
+func (s *Store) isPendingTask(taskID uint) bool {
+	for _, t := range s.pendingTasks {
+		if t.ID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingTaskByID returns the waiting task with the given ID, or nil; callers must hold the lock
+func (s *Store) pendingTaskByID(taskID uint) *Task {
+	for _, t := range s.pendingTasks {
+		if t.ID == taskID {
+			return t
+		}
+	}
+	return nil
+}
+
+// removePendingTask snips a task from the pending queue; callers must hold the lock
+func (s *Store) removePendingTask(taskID uint) bool {
+	for i, t := range s.pendingTasks {
+		if t.ID == taskID {
+			s.pendingTasks = append(s.pendingTasks[:i], s.pendingTasks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ListPendingTasks returns the tasks waiting for an available agent, in the order they will be assigned
+func (s *Store) ListPendingTasks() ([]*Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ts := make([]*Task, 0, len(s.pendingTasks))
+	for _, t := range s.pendingTasks {
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
+// FindPendingTask returns a copy of the waiting task with the given ID
+func (s *Store) FindPendingTask(taskID uint) (Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, t := range s.pendingTasks {
+		if t.ID == taskID {
+			return t.Clone(), nil
+		}
+	}
+
+	return Task{}, ErrTaskNotFound
+}
+
+// assignPendingTasks attempts to assign every waiting task, in queue order.
+// Tasks that still cannot be assigned remain queued.
+func (s *Store) assignPendingTasks() {
+	// Only one pass at a time, so a waiting task is never picked up twice
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	strategy := s.assignmentStrategy()
+
+	// Tasks displaced back to the queue during a pass are offered in another
+	for retry := true; retry; {
+		retry = false
+		pending, _ := s.ListPendingTasks()
+
+		for _, t := range pending {
+			agentID, returned, err := s.assignTask(t, strategy, ChangeInfo{Actor: ActorSystem})
+			if err == ErrNoSkilledAgents || err == ErrNoAvailableAgents {
+				continue
+			}
+			if err != nil {
+				log.Errorf("Store.assignPendingTasks() --> s.assignTask(%d): %v", t.ID, errors.Cause(err))
+				continue
+			}
+			log.Tracef("Store.assignPendingTasks(): Queued task (ID: %v) assigned to agent (ID: %v)", t.ID, agentID)
+			if returned > 0 {
+				retry = true
+			}
+		}
+	}
+}
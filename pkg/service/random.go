@@ -0,0 +1,40 @@
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random is the source of randomness for agent selection. *rand.Rand is not
+// safe for concurrent use, hence the mutex.
+var random = struct {
+	sync.Mutex
+	src rand.Source
+	rnd *rand.Rand
+}{
+	src: seededSource,
+	rnd: rand.New(seededSource),
+}
+
+var seededSource = rand.NewSource(time.Now().UnixNano())
+
+// SetRandomSource replaces the source of randomness used for agent selection,
+// e.g. with rand.NewSource(seed) for reproducible assignments in tests. It returns
+// the source it replaced, so that tests can put it back when done.
+func SetRandomSource(src rand.Source) (previous rand.Source) {
+	random.Lock()
+	defer random.Unlock()
+
+	previous = random.src
+	random.src, random.rnd = src, rand.New(src)
+	return previous
+}
+
+// randomIntn returns a uniformly random int in [0,n)
+func randomIntn(n int) int {
+	random.Lock()
+	defer random.Unlock()
+
+	return random.rnd.Intn(n)
+}
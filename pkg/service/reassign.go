@@ -0,0 +1,124 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAlreadyAssigned   = fmt.Errorf("Task is already assigned to this agent")
+	ErrAgentLacksSkills  = fmt.Errorf("Agent does not possess the required skills (at the required levels) for this task")
+	ErrAgentNotAvailable = fmt.Errorf("Agent is not currently available for this task priority")
+)
+
+// ReassignOptions adjusts how a task is reassigned
+type ReassignOptions struct {
+	// AgentID names the agent to take the task. If 0, the engine selects one,
+	// as for a new task, from every agent except the current holder.
+	AgentID uint
+
+	// Strategy overrides the store's assignment strategy when the engine selects the agent
+	Strategy AssignmentStrategy
+
+	ChangeInfo
+}
+
+// ReassignTask moves a task from its agent's queue to the front of another's,
+// returning the new agent's ID. The task goes back to the assigned state.
+func (s *Store) ReassignTask(taskID uint, opts ReassignOptions) (assignedAgentID uint, err error) {
+	strategy := opts.Strategy
+	if strategy == nil {
+		strategy = s.assignmentStrategy()
+	}
+
+	s.Lock()
+
+	from, t := s.heldTaskByID(taskID)
+	if t == nil {
+		s.Unlock()
+		return 0, ErrTaskNotFound
+	}
+	err = t.State.checkTransition(TaskAssigned)
+	if err != nil {
+		s.Unlock()
+		return 0, err
+	}
+
+	var to *Agent
+	ci := opts.ChangeInfo
+	if opts.AgentID != 0 {
+		to, err = s.eligibleAgent(opts.AgentID, t, from)
+		if err != nil {
+			s.Unlock()
+			return 0, err
+		}
+	} else {
+		to, err = s.selectAgentExcluding(t, strategy, from.ID)
+		if err != nil {
+			s.Unlock()
+			return 0, err
+		}
+		if ci.Reason == "" {
+			ci.Reason = fmt.Sprintf("Selected by %s strategy", strategy.Name())
+		}
+	}
+
+	event := newTaskEvent(TaskEventReassigned, t, TaskAssigned, to.ID, ci)
+	event.FromAgentID = from.ID
+
+	now := time.Now()
+	moved := t.Clone()
+	moved.AssignedAgent = nil
+	moved.AssignmentTime = now
+	moved.State = TaskAssigned
+	err = s.commit(&JournalEntry{Op: OpReassignTask, Time: now, TaskID: taskID, AgentID: to.ID, Task: &moved, Events: []TaskEvent{event}})
+	s.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// The previous holder may now be free to take a waiting task
+	s.assignPendingTasks()
+
+	return to.ID, nil
+}
+
+// eligibleAgent returns the named agent, provided they may take the task off its current holder; callers must hold the lock
+func (s *Store) eligibleAgent(agentID uint, t *Task, holder *Agent) (*Agent, error) {
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		return nil, ErrAgentNotFound
+	}
+	if agent == holder {
+		return nil, ErrAlreadyAssigned
+	}
+	switch stage, _ := s.agentRejection(agent, t, time.Now()); stage {
+	case "":
+	case StageDeactivated:
+		return nil, ErrAgentDeactivated
+	case StageSkills:
+		return nil, ErrAgentLacksSkills
+	default:
+		return nil, ErrAgentNotAvailable
+	}
+	return agent, nil
+}
+
+// selectAgentExcluding runs the normal selection for the task over every agent but the
+// excluded one (0 for none): the selection stages, then the strategy's choice among the
+// best-matched candidates; callers must hold the lock
+func (s *Store) selectAgentExcluding(t *Task, strategy AssignmentStrategy, excludeID uint) (*Agent, error) {
+	_, availableAgentPool, err := s.evaluateAgents(t, time.Now(), excludeID)
+	if err != nil {
+		return nil, err
+	}
+
+	selectedAgent, err := strategy.SelectAgent(t, availableAgentPool.FilterForBestMatch(t))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s.SelectAgent()", strategy.Name())
+	}
+
+	return s.agentByID(selectedAgent.ID), nil
+}
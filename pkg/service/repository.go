@@ -0,0 +1,89 @@
+package service
+
+import (
+	"time"
+)
+
+// Repository is the set of data store operations consumed by the HTTP layer.
+// Store (memory) is the reference implementation; alternate backends only
+// need to satisfy this interface to be plugged into the API server.
+type Repository interface {
+	// Skills
+	ListSkills() ([]SkillDefinition, error)
+	FindSkill(name Skill) (SkillDefinition, error)
+	AddSkill(sd SkillDefinition) error
+	UpdateSkill(sd SkillDefinition) error
+	DeleteSkill(name Skill) error
+	ValidateSkills(ss Skills) error
+
+	// Agents
+	AddAgents(agents []*Agent) error
+	FindAgent(agentID uint) (*Agent, error)
+	ListAgents() ([]*Agent, error)
+	UpdateAgentSkills(agentID uint, ss Skills, levels SkillLevels) error
+	SetAgentCapacity(agentID uint, c Capacity) error
+	SetAgentPresence(agentID uint, p Presence, actor string) error
+	SetAgentSchedule(agentID uint, sch *Schedule) error
+	PresenceLog(agentID uint) ([]PresenceChange, error)
+	DeactivateAgent(agentID uint, policy InFlightPolicy) error
+	ActivateAgent(agentID uint) error
+	DeleteAgent(agentID uint, policy InFlightPolicy) error
+
+	// Assignment
+	SetAssignmentStrategy(st AssignmentStrategy)
+	SetAvoidShiftOverrun(avoid bool)
+	SetPreemptionPolicy(policy PreemptionPolicy) error
+	AssignPendingTasks()
+
+	// Service levels
+	SetSLAPolicy(pol SLAPolicy) error
+	SLAPolicy() SLAPolicy
+	CheckSLAs(now time.Time) error
+	ListSLATasks(status SLAStatus) ([]Task, error)
+
+	// Escalation
+	SetEscalationRules(rules []EscalationRule) error
+	EscalationRules() []EscalationRule
+	Escalate(now time.Time) error
+
+	// Active tasks
+	AddTaskToAgent(t *Task) (assignedAgentID uint, taskID uint, err error)
+	AddTaskToAgentWithOptions(t *Task, opts AssignmentOptions) (assignedAgentID uint, taskID uint, err error)
+	DryRunAssignment(t *Task, opts AssignmentOptions) (AssignmentDryRun, error)
+	FindTask(taskID uint) (*Task, error)
+	FindTaskWithAgent(taskID uint) (Task, error)
+	GetTask(taskID uint) (Task, error)
+	DeleteTask(taskID uint) error
+
+	// Pending tasks
+	ListPendingTasks() ([]*Task, error)
+	FindPendingTask(taskID uint) (Task, error)
+
+	// Task state transitions
+	StartTask(taskID uint, ci ChangeInfo) error
+	PauseTask(taskID uint, ci ChangeInfo) error
+	ResumeTask(taskID uint, ci ChangeInfo) error
+	CompleteTask(taskID uint, ci ChangeInfo) error
+	CompleteTaskWithOptions(taskID uint, opts CompletionOptions) error
+	MarkAsCompleted(taskID uint) error
+	CancelTask(taskID uint, ci ChangeInfo) error
+	ReopenTask(taskID uint, ci ChangeInfo) error
+	ReassignTask(taskID uint, opts ReassignOptions) (assignedAgentID uint, err error)
+
+	// Task history
+	TaskHistory(taskID uint) ([]TaskEvent, error)
+
+	// Completed tasks
+	ListCompletedTasks() ([]*Task, error)
+	QueryCompletedTasks(q CompletedTaskQuery) (CompletedTaskPage, error)
+
+	// Cancelled tasks
+	ListCancelledTasks() ([]*Task, error)
+
+	// ID allocation
+	NextAgentID() uint
+	NextTaskID() uint
+}
+
+// Ensure Store satisfies Repository
+var _ Repository = (*Store)(nil)
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Weekday is a day of the week, encoded by its lowercase name, e.g. "monday"
+type Weekday time.Weekday
+
+func (d Weekday) MarshalText() ([]byte, error) {
+	if d < Weekday(time.Sunday) || d > Weekday(time.Saturday) {
+		return nil, fmt.Errorf("Invalid day: %d", int(d))
+	}
+	return []byte(strings.ToLower(time.Weekday(d).String())), nil
+}
+
+func (d *Weekday) UnmarshalText(text []byte) error {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.EqualFold(string(text), wd.String()) {
+			*d = Weekday(wd)
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid day: %q (expected e.g. monday)", text)
+}
+
+// Shift is a weekly working period, in its schedule's time zone. Times are
+// "HH:MM", with "24:00" for midnight at the end of the day; a shift that ends
+// at or before its start runs overnight into the next day.
+type Shift struct {
+	Day   Weekday `json:"day"`
+	Start string  `json:"start"`
+	End   string  `json:"end"`
+}
+
+// Schedule is an agent's weekly shift calendar
+type Schedule struct {
+	// TimeZone is an IANA time zone name, e.g. "America/New_York"; UTC if empty
+	TimeZone string  `json:"time_zone,omitempty"`
+	Shifts   []Shift `json:"shifts"`
+
+	// Holidays are dates ("YYYY-MM-DD", in TimeZone) the agent does not work;
+	// a shift starting on a holiday is skipped
+	Holidays []string `json:"holidays,omitempty"`
+}
+
+const holidayLayout = "2006-01-02"
+
+// maxShiftChain bounds how many back-to-back shifts are followed to find when
+// an agent's working time ends, so that a round-the-clock schedule terminates
+const maxShiftChain = 14
+
+func (sch *Schedule) IsValid() error {
+	if _, err := time.LoadLocation(sch.TimeZone); err != nil {
+		return fmt.Errorf("Invalid time_zone: %q", sch.TimeZone)
+	}
+	if len(sch.Shifts) == 0 {
+		return fmt.Errorf("A schedule needs at least one shift")
+	}
+	for _, sh := range sch.Shifts {
+		if sh.Day < Weekday(time.Sunday) || sh.Day > Weekday(time.Saturday) {
+			return fmt.Errorf("Invalid shift day: %d", int(sh.Day))
+		}
+		if _, err := parseClock(sh.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(sh.End); err != nil {
+			return err
+		}
+	}
+	for _, h := range sch.Holidays {
+		if _, err := time.Parse(holidayLayout, h); err != nil {
+			return fmt.Errorf("Invalid holiday: %q (expected YYYY-MM-DD)", h)
+		}
+	}
+	return nil
+}
+
+// parseClock converts "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	var h, m int
+	_, err := fmt.Sscanf(s, "%d:%d", &h, &m)
+	if err != nil || len(s) != 5 || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("Invalid shift time: %q (expected HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// shiftEndAt returns the end of the latest-ending shift in progress at the given time
+func (sch *Schedule) shiftEndAt(at time.Time) (end time.Time, onShift bool) {
+	loc, err := time.LoadLocation(sch.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	local := at.In(loc)
+
+	// An overnight shift from yesterday may still be running
+	for _, offset := range []int{0, -1} {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, loc)
+		if sch.isHoliday(day) {
+			continue
+		}
+		for _, sh := range sch.Shifts {
+			if time.Weekday(sh.Day) != day.Weekday() {
+				continue
+			}
+			startMin, _ := parseClock(sh.Start)
+			endMin, _ := parseClock(sh.End)
+			if endMin <= startMin {
+				endMin += 24 * 60
+			}
+			start := day.Add(time.Duration(startMin) * time.Minute)
+			shiftEnd := day.Add(time.Duration(endMin) * time.Minute)
+			if !at.Before(start) && at.Before(shiftEnd) && shiftEnd.After(end) {
+				end, onShift = shiftEnd, true
+			}
+		}
+	}
+	return end, onShift
+}
+
+func (sch *Schedule) isHoliday(day time.Time) bool {
+	date := day.Format(holidayLayout)
+	for _, h := range sch.Holidays {
+		if h == date {
+			return true
+		}
+	}
+	return false
+}
+
+// OnShiftUntil reports whether the schedule has a shift in progress at the given
+// time and, if so, when the agent's working time ends, following back-to-back shifts
+func (sch *Schedule) OnShiftUntil(at time.Time) (until time.Time, onShift bool) {
+	until, onShift = sch.shiftEndAt(at)
+	if !onShift {
+		return time.Time{}, false
+	}
+	for i := 0; i < maxShiftChain; i++ {
+		next, ok := sch.shiftEndAt(until)
+		if !ok {
+			break
+		}
+		until = next
+	}
+	return until, true
+}
+
+// OnShiftUntil reports whether the agent is on shift at the given time and, if so,
+// until when; agents without a schedule are always on shift, with no end
+func (a *Agent) OnShiftUntil(at time.Time) (until time.Time, onShift bool) {
+	if a.Schedule == nil {
+		return time.Time{}, true
+	}
+	return a.Schedule.OnShiftUntil(at)
+}
+
+// CanFinishBeforeShiftEnds reports whether the agent is on shift at the given
+// time, with at least the task's estimated duration left before their shift ends
+func (a *Agent) CanFinishBeforeShiftEnds(t *Task, at time.Time) bool {
+	until, onShift := a.OnShiftUntil(at)
+	if !onShift {
+		return false
+	}
+	return until.IsZero() || until.Sub(at) >= t.EstimatedDuration()
+}
+
+// SetAgentSchedule replaces an agent's shift calendar; nil means always on shift
+func (s *Store) SetAgentSchedule(agentID uint, sch *Schedule) error {
+	if sch != nil {
+		err := sch.IsValid()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.Lock()
+
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		s.Unlock()
+		return ErrAgentNotFound
+	}
+
+	updated := agent.Clone()
+	updated.Schedule = sch
+	updated.Tasks = nil
+	err := s.commit(&JournalEntry{Op: OpSetAgentSchedule, AgentID: agentID, Agent: &updated})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The agent may now be on shift, and free for waiting tasks
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// SetAvoidShiftOverrun sets whether tasks with an estimated duration are kept from
+// agents whose shift ends before they could finish them
+func (s *Store) SetAvoidShiftOverrun(avoid bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.avoidShiftOverrun = avoid
+}
+
+// AssignPendingTasks retries every waiting task. Agents come on shift without any
+// change to the store, so this is called periodically to pick up their tasks.
+func (s *Store) AssignPendingTasks() {
+	s.assignPendingTasks()
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// FilterStage is the step of agent selection that rules an agent out for a task
+type FilterStage string
+
+const (
+	StageDeactivated FilterStage = "deactivated" // The agent is deactivated
+	StageSkills      FilterStage = "skills"      // Lacks a required skill, or the minimum level in one
+	StagePresence    FilterStage = "presence"    // Away or offline
+	StageCapacity    FilterStage = "capacity"    // At their capacity limit for the task's priority
+	StagePriority    FilterStage = "priority"    // Holds a task of equal or higher priority
+	StageShift       FilterStage = "shift"       // Off shift, or leaving before they could finish the task
+)
+
+// selectionStages are the filters an agent must pass, in order, to be selected for a
+// task. Each returns why the agent is ruled out, or "" if they pass.
+var selectionStages = []struct {
+	stage  FilterStage
+	reject func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string
+}{
+	{StageDeactivated, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		if a.Deactivated {
+			return "Agent is deactivated"
+		}
+		return ""
+	}},
+	{StageSkills, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		for _, skill := range t.ReqSkills {
+			if !a.Skills.Includes(skill) {
+				return fmt.Sprintf("Lacks required skill %v", skill)
+			}
+			if level, min := a.SkillLevels.level(skill), t.MinSkillLevels.level(skill); level < min {
+				return fmt.Sprintf("Level %d in %v is below the required %d", level, skill, min)
+			}
+		}
+		return ""
+	}},
+	{StagePresence, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		if a.Presence != PresenceOnline {
+			return fmt.Sprintf("Agent is %v", a.Presence)
+		}
+		return ""
+	}},
+	{StageCapacity, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		if !a.Capacity.allows(a.Tasks, t.Priority) {
+			return fmt.Sprintf("Holds %d task(s), at the limit for %v tasks", len(a.Tasks), t.Priority)
+		}
+		return ""
+	}},
+	{StagePriority, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		if held := a.blockingTask(t.Priority); held != nil {
+			return fmt.Sprintf("Holds task %d of %v priority", held.ID, held.Priority)
+		}
+		return ""
+	}},
+	{StageShift, func(a *Agent, t *Task, at time.Time, avoidOverrun bool) string {
+		if _, onShift := a.OnShiftUntil(at); !onShift {
+			return "Agent is off shift"
+		}
+		if avoidOverrun && !a.CanFinishBeforeShiftEnds(t, at) {
+			return "Shift ends before the task's estimated duration"
+		}
+		return ""
+	}},
+}
+
+// agentRejection returns the first stage of selection that rules the agent out for the
+// task at the given time, with the reason, or "" if they may take it; callers must hold the lock
+func (s *Store) agentRejection(a *Agent, t *Task, at time.Time) (FilterStage, string) {
+	for _, st := range selectionStages {
+		if reason := st.reject(a, t, at, s.avoidShiftOverrun); reason != "" {
+			return st.stage, reason
+		}
+	}
+	return "", ""
+}
+
+// evaluateAgents runs every agent but the excluded one through the selection stages for
+// the task, returning how each fared and the remaining candidates, in store order. With
+// no candidates, the error is ErrNoSkilledAgents if no agent could ever take the task,
+// or else ErrNoAvailableAgents; callers must hold the lock
+func (s *Store) evaluateAgents(t *Task, at time.Time, excludeID uint) (evals []AgentEvaluation, candidates Agents, err error) {
+	evals = []AgentEvaluation{}
+	candidates = Agents{}
+	skilled := false
+	for _, a := range s.agents {
+		if a.ID == excludeID {
+			continue
+		}
+		stage, reason := s.agentRejection(a, t, at)
+		evals = append(evals, AgentEvaluation{AgentID: a.ID, Name: a.Name, EliminatedBy: stage, Reason: reason})
+		switch stage {
+		case "":
+			candidates = append(candidates, *a)
+			skilled = true
+		case StagePresence, StageCapacity, StagePriority, StageShift:
+			skilled = true
+		}
+	}
+
+	if len(candidates) > 0 {
+		return evals, candidates, nil
+	}
+	// Skilled agents who are away, busy or off shift will be free later; the task waits for them
+	if skilled {
+		return evals, candidates, ErrNoAvailableAgents
+	}
+	return evals, candidates, ErrNoSkilledAgents
+}
@@ -2,25 +2,56 @@ package service
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Skill string
 
 type Skills []Skill
 
+// Seed skills, registered in every new store
 const (
 	Skill1 Skill = "skill1"
 	Skill2 Skill = "skill2"
 	Skill3 Skill = "skill3"
 )
 
+// SkillDefinition is a registered skill, which tasks may require and agents may possess
+type SkillDefinition struct {
+	Name        Skill  `json:"name"`
+	Description string `json:"description"`
+}
+
+func BuildSeedSkills() []*SkillDefinition {
+	return []*SkillDefinition{
+		&SkillDefinition{Name: Skill1, Description: "Seed skill 1"},
+		&SkillDefinition{Name: Skill2, Description: "Seed skill 2"},
+		&SkillDefinition{Name: Skill3, Description: "Seed skill 3"},
+	}
+}
+
+func (sd *SkillDefinition) IsValid() error {
+	return sd.Name.IsValid()
+}
+
+// IsValid checks that the skill is well-formed; whether it is registered is up to the Store
+func (s Skill) IsValid() error {
+	if string(s) == "" {
+		return fmt.Errorf("Skill name is required")
+	}
+	if strings.TrimSpace(string(s)) != string(s) || strings.ContainsAny(string(s), "/?#") {
+		return fmt.Errorf("Invalid Skill: %v", s)
+	}
+	return nil
+}
+
 func (s *Skills) IsValid() error {
 	if len(*s) < 1 {
 		return fmt.Errorf("At least one Required Skill is required")
 	}
 	for _, skill := range *s {
-		if skill != Skill1 && skill != Skill2 && skill != Skill3 {
-			return fmt.Errorf("Invalid Skill: %v", skill)
+		if err := skill.IsValid(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -34,3 +65,33 @@ func (s *Skills) Includes(targetSkill Skill) bool {
 	}
 	return false
 }
+
+// Proficiency levels run from novice to expert; an agent who declares no level for a skill is a novice
+const (
+	MinSkillLevel = 1
+	MaxSkillLevel = 5
+)
+
+// SkillLevels maps skills to proficiency levels: those an agent has, or the minimum a task requires
+type SkillLevels map[Skill]int
+
+// IsValid checks that every level is in range and belongs to one of the skills ss
+func (sl SkillLevels) IsValid(ss Skills) error {
+	for skill, level := range sl {
+		if !ss.Includes(skill) {
+			return fmt.Errorf("Skill level given for %v, which is not among the skills", skill)
+		}
+		if level < MinSkillLevel || level > MaxSkillLevel {
+			return fmt.Errorf("Invalid Skill level for %v: %d (expected %d to %d)", skill, level, MinSkillLevel, MaxSkillLevel)
+		}
+	}
+	return nil
+}
+
+// level returns the level given for the skill, or MinSkillLevel if none is
+func (sl SkillLevels) level(skill Skill) int {
+	if level, ok := sl[skill]; ok {
+		return level
+	}
+	return MinSkillLevel
+}
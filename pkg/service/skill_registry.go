@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSkillNotFound = fmt.Errorf("Skill not found")
+	ErrSkillExists   = fmt.Errorf("Skill already exists")
+	ErrSkillInUse    = fmt.Errorf("Skill is in use by an agent or an unfinished task")
+)
+
+// ValidateSkills ensures every skill is registered
+func (s *Store) ValidateSkills(ss Skills) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.validateSkills(ss)
+}
+
+// validateSkills is ValidateSkills for callers already holding the lock
+func (s *Store) validateSkills(ss Skills) error {
+	for _, skill := range ss {
+		if _, ok := s.skills[skill]; !ok {
+			return fmt.Errorf("Invalid Skill: %v", skill)
+		}
+	}
+	return nil
+}
+
+// ListSkills returns every registered skill, ordered by name
+func (s *Store) ListSkills() ([]SkillDefinition, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sds := make([]SkillDefinition, 0, len(s.skills))
+	for _, sd := range s.skills {
+		sds = append(sds, *sd)
+	}
+	sort.Slice(sds, func(i, j int) bool {
+		return sds[i].Name < sds[j].Name
+	})
+
+	return sds, nil
+}
+
+func (s *Store) FindSkill(name Skill) (SkillDefinition, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sd, ok := s.skills[name]
+	if !ok {
+		return SkillDefinition{}, ErrSkillNotFound
+	}
+
+	return *sd, nil
+}
+
+// AddSkill registers a new skill
+func (s *Store) AddSkill(sd SkillDefinition) error {
+	err := sd.IsValid()
+	if err != nil {
+		return errors.Wrap(err, "sd.IsValid()")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.skills[sd.Name]; ok {
+		return ErrSkillExists
+	}
+
+	return s.commit(&JournalEntry{Op: OpPutSkill, Skill: &sd})
+}
+
+// UpdateSkill replaces the details (i.e. description) of a registered skill
+func (s *Store) UpdateSkill(sd SkillDefinition) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.skills[sd.Name]; !ok {
+		return ErrSkillNotFound
+	}
+
+	return s.commit(&JournalEntry{Op: OpPutSkill, Skill: &sd})
+}
+
+// DeleteSkill unregisters a skill, provided no agent possesses it and no active or pending task requires (or prefers) it
+func (s *Store) DeleteSkill(name Skill) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.skills[name]; !ok {
+		return ErrSkillNotFound
+	}
+
+	for _, a := range s.agents {
+		if a.Skills.Includes(name) {
+			return ErrSkillInUse
+		}
+		for _, t := range a.Tasks {
+			if t.ReqSkills.Includes(name) || t.PrefSkills.Includes(name) {
+				return ErrSkillInUse
+			}
+		}
+	}
+	for _, t := range s.pendingTasks {
+		if t.ReqSkills.Includes(name) || t.PrefSkills.Includes(name) {
+			return ErrSkillInUse
+		}
+	}
+
+	return s.commit(&JournalEntry{Op: OpDeleteSkill, Skill: &SkillDefinition{Name: name}})
+}
+
+// putSkill adds or replaces a skill in the registry; callers must hold the lock
+func (s *Store) putSkill(sd *SkillDefinition) {
+	if s.skills == nil {
+		s.skills = map[Skill]*SkillDefinition{}
+	}
+	s.skills[sd.Name] = sd
+}
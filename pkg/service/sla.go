@@ -0,0 +1,258 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SLAStatus is how a task stands against its service level targets
+type SLAStatus string
+
+const (
+	SLANone     SLAStatus = ""         // No targets apply to the task
+	SLAOnTrack  SLAStatus = "on_track" // Open, with time to spare on every target
+	SLAAtRisk   SLAStatus = "at_risk"  // Open, with a target close to being missed
+	SLABreached SLAStatus = "breached" // A target was missed
+	SLAMet      SLAStatus = "met"      // Completed, with every target met
+)
+
+// DefaultSLAAtRisk is the fraction of the time allowed by a target after which it is at risk
+const DefaultSLAAtRisk = 0.8
+
+// SLATarget is the service level expected for tasks of a priority, in seconds from
+// a task's creation (or its reopening); zero means no target
+type SLATarget struct {
+	TimeToAssignSeconds   int `json:"time_to_assign_seconds,omitempty"`
+	TimeToCompleteSeconds int `json:"time_to_complete_seconds,omitempty"`
+}
+
+// SLAPolicy sets the service level targets for each priority
+type SLAPolicy struct {
+	Targets map[Priority]SLATarget `json:"targets"`
+
+	// AtRisk is the fraction of the time allowed by a target after which it is at
+	// risk of being missed; DefaultSLAAtRisk if zero
+	AtRisk float64 `json:"at_risk"`
+}
+
+func (pol *SLAPolicy) IsValid() error {
+	for p, target := range pol.Targets {
+		if err := p.IsValid(); err != nil {
+			return err
+		}
+		if target.TimeToAssignSeconds < 0 || target.TimeToCompleteSeconds < 0 {
+			return fmt.Errorf("Invalid SLA target for priority %v: targets cannot be negative", p)
+		}
+	}
+	if pol.AtRisk < 0 || pol.AtRisk > 1 {
+		return fmt.Errorf("Invalid SLA at-risk fraction: %v (expected between 0 and 1)", pol.AtRisk)
+	}
+	return nil
+}
+
+// ParseSLATargets parses a comma-separated list of priority:time-to-assign:time-to-complete
+// targets, e.g. "high:5m:1h,low::8h"; an empty duration means no target
+func ParseSLATargets(s string) (map[Priority]SLATarget, error) {
+	targets := map[Priority]SLATarget{}
+	if strings.TrimSpace(s) == "" {
+		return targets, nil
+	}
+
+	parseSeconds := func(d string) (int, error) {
+		if d == "" {
+			return 0, nil
+		}
+		parsed, err := time.ParseDuration(d)
+		if err != nil || parsed < 0 {
+			return 0, fmt.Errorf("Invalid SLA duration: %q", d)
+		}
+		return int(parsed.Seconds()), nil
+	}
+
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("Invalid SLA target: %q (expected priority:time-to-assign:time-to-complete)", spec)
+		}
+		p := Priority(parts[0])
+		if err := p.IsValid(); err != nil {
+			return nil, err
+		}
+		var target SLATarget
+		var err error
+		target.TimeToAssignSeconds, err = parseSeconds(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		target.TimeToCompleteSeconds, err = parseSeconds(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		targets[p] = target
+	}
+	return targets, nil
+}
+
+// slaDue is a single deadline a task is held to: started at start, to be met by due
+type slaDue struct {
+	start, due time.Time
+	metAt      time.Time // Zero until met
+}
+
+// Evaluate rates the task against its priority's targets and its own deadline, as of now.
+// Targets run from the task's creation, or from when it was last reopened.
+func (pol *SLAPolicy) Evaluate(t *Task, now time.Time) SLAStatus {
+	target := pol.Targets[t.Priority]
+	dues := []slaDue{}
+
+	start := t.CreatedTime
+	if !t.ReopenedTime.IsZero() {
+		start = t.ReopenedTime
+	}
+	if target.TimeToAssignSeconds > 0 {
+		dues = append(dues, slaDue{
+			start: start,
+			due:   start.Add(time.Duration(target.TimeToAssignSeconds) * time.Second),
+			metAt: t.FirstAssignedTime,
+		})
+	}
+	if target.TimeToCompleteSeconds > 0 {
+		dues = append(dues, slaDue{
+			start: start,
+			due:   start.Add(time.Duration(target.TimeToCompleteSeconds) * time.Second),
+			metAt: t.CompletedTime,
+		})
+	}
+	if !t.Deadline.IsZero() {
+		dues = append(dues, slaDue{start: start, due: t.Deadline, metAt: t.CompletedTime})
+	}
+	if len(dues) == 0 {
+		return SLANone
+	}
+
+	atRisk := pol.AtRisk
+	if atRisk == 0 {
+		atRisk = DefaultSLAAtRisk
+	}
+
+	status := SLAOnTrack
+	if t.State == TaskComplete {
+		status = SLAMet
+	}
+	for _, d := range dues {
+		if !d.metAt.IsZero() {
+			if d.metAt.After(d.due) {
+				return SLABreached
+			}
+			continue
+		}
+		if t.State == TaskComplete || t.State == TaskCancelled {
+			continue
+		}
+		if now.After(d.due) {
+			return SLABreached
+		}
+		allowed := d.due.Sub(d.start)
+		if allowed <= 0 || float64(now.Sub(d.start)) >= atRisk*float64(allowed) {
+			status = SLAAtRisk
+		}
+	}
+	return status
+}
+
+// SetSLAPolicy sets the service level targets tasks are held to
+func (s *Store) SetSLAPolicy(pol SLAPolicy) error {
+	err := pol.IsValid()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.slaPolicy = pol
+	return nil
+}
+
+// SLAPolicy returns the service level targets tasks are held to
+func (s *Store) SLAPolicy() SLAPolicy {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.slaPolicy
+}
+
+// CheckSLAs re-rates every open task against the SLA policy as of now, recording
+// each change of status in the task's history. It is called periodically, as
+// tasks fall behind their targets without any change to the store.
+func (s *Store) CheckSLAs(now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// Open tasks, with the agent holding them (0 while waiting)
+	type openTask struct {
+		t       *Task
+		agentID uint
+	}
+	open := []openTask{}
+	for _, t := range s.pendingTasks {
+		open = append(open, openTask{t, 0})
+	}
+	for _, a := range s.agents {
+		for _, t := range a.Tasks {
+			open = append(open, openTask{t, a.ID})
+		}
+	}
+
+	for _, o := range open {
+		t, agentID := o.t, o.agentID
+		status := s.slaPolicy.Evaluate(t, now)
+		if status == t.SLAStatus {
+			continue
+		}
+
+		event := newTaskEvent(TaskEventSLAChanged, t, t.State, agentID, ChangeInfo{Actor: ActorSystem, Reason: string(status)})
+		updated := t.Clone()
+		updated.SLAStatus = status
+		err := s.commit(&JournalEntry{Op: OpSetTaskSLA, Time: now, TaskID: t.ID, Task: &updated, Events: []TaskEvent{event}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListSLATasks returns copies of the open tasks with the given SLA status, or with
+// any status needing attention (at risk or breached) if status is empty; oldest first
+func (s *Store) ListSLATasks(status SLAStatus) ([]Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ts := []Task{}
+	add := func(t *Task, agent *Agent) {
+		if (status == SLANone && (t.SLAStatus == SLAAtRisk || t.SLAStatus == SLABreached)) || (status != SLANone && t.SLAStatus == status) {
+			c := t.Clone()
+			if agent != nil {
+				slim := agent.SlimClone()
+				c.AssignedAgent = &slim
+			}
+			ts = append(ts, c)
+		}
+	}
+	for _, t := range s.pendingTasks {
+		add(t, nil)
+	}
+	for _, a := range s.agents {
+		for _, t := range a.Tasks {
+			add(t, a)
+		}
+	}
+
+	sort.SliceStable(ts, func(i, j int) bool {
+		return ts[i].CreatedTime.Before(ts[j].CreatedTime)
+	})
+	return ts, nil
+}
@@ -6,33 +6,93 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
-// Store (memory) keeps data in memory
+// Store (memory) keeps data in memory. NewStore returns a store with the seed
+// skills registered; the zero value has no skills until some are added.
 type Store struct {
 	sync.RWMutex
+	skills         map[Skill]*SkillDefinition
 	agents         []*Agent
+	pendingTasks   []*Task // Ordered by priority, then arrival
 	completedTasks []*Task
+	cancelledTasks []*Task // Kept apart from completed tasks, so they never count as done
+
+	// history is the append-only audit trail of every task, by task ID
+	history map[uint][]TaskEvent
+
+	// presenceLog records every change in each agent's presence, by agent ID
+	presenceLog map[uint][]PresenceChange
+
+	// retiredAgentID is the highest ID of any deleted agent, so that it is never reissued
+	retiredAgentID uint
+
+	// retiredTaskID is the highest ID of any deleted task, so that it is never reissued
+	// (and never inherits the deleted task's history)
+	retiredTaskID uint
+
+	// drainMu serializes passes over the pending queue
+	drainMu sync.Mutex
+
+	// strategy selects agents for assignment; the standard strategy is used if unset
+	strategy AssignmentStrategy
+
+	// escalationRules escalate tasks that have spent too long in a state
+	escalationRules []EscalationRule
+
+	// slaPolicy holds tasks to service level targets by priority
+	slaPolicy SLAPolicy
+
+	// preemption decides what happens to tasks displaced by a higher-priority one
+	preemption PreemptionPolicy
+
+	// avoidShiftOverrun keeps tasks from agents whose shift ends before their estimated duration
+	avoidShiftOverrun bool
+
+	// journal, if set, receives every mutation before it is applied
+	journal Journal
 }
 
-func NewStore(agents []*Agent, completed []*Task) *Store {
+func NewStore(agents []*Agent, completed []*Task, pending ...*Task) *Store {
 	for i := 0; i < len(agents); i++ {
 		agents[i].ID = uint(i + 1)
 	}
-	return &Store{
+	s := &Store{
 		agents:         agents,
 		completedTasks: completed,
 	}
+	for _, sd := range BuildSeedSkills() {
+		s.putSkill(sd)
+	}
+	for _, t := range pending {
+		s.insertPendingTask(t)
+	}
+	return s
 }
 
 func (s *Store) AddAgents(agents []*Agent) error {
 	for i := 0; i < len(agents); i++ {
-		agents[i].ID = s.NextAgentID()
 		s.Lock()
-		s.agents = append(s.agents, agents[i])
+		err := agents[i].IsValid()
+		if err == nil {
+			err = s.validateSkills(agents[i].Skills)
+		}
+		if err != nil {
+			s.Unlock()
+			return errors.Wrapf(err, "Agent %q", agents[i].Name)
+		}
+		agents[i].ID = s.NextAgentID()
+		err = s.commit(&JournalEntry{Op: OpAddAgent, Agent: agents[i]})
 		s.Unlock()
+		if err != nil {
+			return errors.Wrap(err, "s.commit(OpAddAgent)")
+		}
 	}
 
+	// New agents may be able to take waiting tasks
+	s.assignPendingTasks()
+
 	return nil
 }
 
@@ -40,13 +100,22 @@ func (s *Store) FindAgent(agentID uint) (*Agent, error) {
 	s.RLock()
 	defer s.RUnlock()
 
+	agent := s.agentByID(agentID)
+	if agent == nil {
+		return nil, ErrAgentNotFound
+	}
+
+	return agent, nil
+}
+
+// agentByID returns the stored agent with the given ID, or nil; callers must hold the lock
+func (s *Store) agentByID(agentID uint) *Agent {
 	for _, a := range s.agents {
 		if a.ID == agentID {
-			return a, nil
+			return a
 		}
 	}
-
-	return nil, fmt.Errorf("Agent not found")
+	return nil
 }
 
 func (s *Store) ListAgents() ([]*Agent, error) {
@@ -74,30 +143,66 @@ func (s *Store) FindTask(taskID uint) (*Task, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("Task not found")
+	return nil, ErrTaskNotFound
 }
 
 func (s *Store) DeleteTask(taskID uint) error {
 	s.Lock()
 	defer s.Unlock()
 
+	task, err := s.findTaskWithAgent(taskID)
+	if err != nil {
+		return err
+	}
+	event := newTaskEvent(TaskEventDeleted, &task, task.State, 0, ChangeInfo{})
+
+	return s.commit(&JournalEntry{Op: OpDeleteTask, TaskID: taskID, Events: []TaskEvent{event}})
+}
+
+// removeTask snips a task from its agent's queue, returning that agent; callers must hold the lock
+func (s *Store) removeTask(taskID uint) (*Agent, error) {
 	for i := 0; i < len(s.agents); i++ {
 		for j := 0; j < len(s.agents[i].Tasks); j++ {
 			if s.agents[i].Tasks[j].ID == taskID {
 				// Delete by snipping task from slice
 				s.agents[i].Tasks = append(s.agents[i].Tasks[:j], s.agents[i].Tasks[j+1:]...)
-				return nil
+				return s.agents[i], nil
 			}
 		}
 	}
 
-	return fmt.Errorf("Task not found")
+	return nil, ErrTaskNotFound
 }
 
 func (s *Store) FindTaskWithAgent(taskID uint) (Task, error) {
 	s.RLock()
 	defer s.RUnlock()
 
+	return s.findTaskWithAgent(taskID)
+}
+
+// GetTask returns a copy of the task with the given ID, wherever it is: held by
+// an agent (along with that agent, as FindTaskWithAgent), waiting, completed or cancelled
+func (s *Store) GetTask(taskID uint) (Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	task, err := s.findTaskWithAgent(taskID)
+	if err == nil {
+		return task, nil
+	}
+	if t := s.pendingTaskByID(taskID); t != nil {
+		return t.Clone(), nil
+	}
+	if t := s.finishedTaskByID(taskID); t != nil {
+		return t.Clone(), nil
+	}
+
+	return Task{}, ErrTaskNotFound
+}
+
+// findTaskWithAgent is FindTaskWithAgent for callers already holding the lock
+func (s *Store) findTaskWithAgent(taskID uint) (Task, error) {
 	for _, a := range s.agents {
 		for _, t := range a.Tasks {
 			if t.ID == taskID {
@@ -109,117 +214,266 @@ func (s *Store) FindTaskWithAgent(taskID uint) (Task, error) {
 		}
 	}
 
-	return Task{}, fmt.Errorf("Task not found")
+	return Task{}, ErrTaskNotFound
+}
+
+var (
+	ErrTaskNotFound      = fmt.Errorf("Task not found")
+	ErrNoSkilledAgents   = fmt.Errorf("No existing agents possess the required skills for this task")
+	ErrNoAvailableAgents = fmt.Errorf("No agents are currently available for this task priority")
+)
+
+// AssignmentOptions adjusts how a single task is assigned
+type AssignmentOptions struct {
+	// Strategy overrides the store's assignment strategy, if set
+	Strategy AssignmentStrategy
+
+	// Actor is recorded in the task's history as having submitted it
+	Actor string
+}
+
+// SetAssignmentStrategy sets the strategy used to select agents for tasks, unless overridden per task
+func (s *Store) SetAssignmentStrategy(st AssignmentStrategy) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.strategy = st
 }
 
-// AddTaskToAgent adds a task to an agents list, or returns an error if no agents are available
+// assignmentStrategy returns the strategy to use absent any per-task override
+func (s *Store) assignmentStrategy() AssignmentStrategy {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.strategy == nil {
+		return defaultAssignmentStrategy
+	}
+	return s.strategy
+}
+
+// AddTaskToAgent adds a task to an agents list, or returns an error if no agents possess the required skills.
+// If skilled agents exist but none are currently available, the task is parked in the pending queue
+// instead, to be assigned automatically once an agent frees up; assignedAgentID is 0 in that case.
 func (s *Store) AddTaskToAgent(t *Task) (assignedAgentID uint, taskID uint, err error) {
+	return s.AddTaskToAgentWithOptions(t, AssignmentOptions{})
+}
+
+// AddTaskToAgentWithOptions is AddTaskToAgent, with per-task adjustments to the assignment process
+func (s *Store) AddTaskToAgentWithOptions(t *Task, opts AssignmentOptions) (assignedAgentID uint, taskID uint, err error) {
 	// Ensure task is valid
 	err = t.IsValid()
 	if err != nil {
 		return 0, 0, errors.Wrap(err, "task.IsValid()")
 	}
-
-	// Find agents with task required skills
-	skilledAgentPool, ok := s.FindAgentsWithNecessarySkills(t.ReqSkills)
-	if !ok {
-		return 0, 0, fmt.Errorf("No existing agents possess the required skills for this task")
+	err = s.ValidateSkills(t.ReqSkills)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "s.ValidateSkills()")
 	}
-
-	// Filter agents for availability for task priority
-	availableAgentPool, ok := skilledAgentPool.FilterForAvailableByPriority(t.Priority)
-	if !ok {
-		return 0, 0, fmt.Errorf("No agents are currently available for this task priority")
+	err = s.ValidateSkills(t.PrefSkills)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "s.ValidateSkills()")
 	}
 
-	// Prefer agents with no tasks assigned
-	var selectedAgent Agent
-	idleAgents, ok := availableAgentPool.FilterForNoTasksAssigned()
-	if ok {
-		// Assign to random agent; ignore errors as we know len(idleAgents) > 0
-		selectedAgent, _ = idleAgents.PluckRandomAgent()
+	// New tasks always receive a freshly allocated ID, and start out waiting for an agent
+	t.ID = 0
+	t.CreatedTime = time.Now()
+	t.State = TaskQueued
+	policy := s.SLAPolicy()
+	t.SLAStatus = policy.Evaluate(t, t.CreatedTime)
+
+	strategy := opts.Strategy
+	if strategy == nil {
+		strategy = s.assignmentStrategy()
+	}
 
-		err = s.addTaskToAgentPush(selectedAgent.ID, t)
+	// Hold off passes over the pending queue until the task is assigned or queued, so an
+	// agent freed in the meantime cannot miss it
+	ci := ChangeInfo{Actor: opts.Actor}
+	s.drainMu.Lock()
+	assignedAgentID, returned, err := s.assignTask(t, strategy, ci)
+	if err == ErrNoAvailableAgents {
+		err = s.enqueueTask(t, ci)
+		s.drainMu.Unlock()
 		if err != nil {
-			return 0, 0, errors.Wrap(err, "s.addTaskToAgentPush()")
+			return 0, 0, errors.Wrap(err, "s.enqueueTask()")
 		}
-		return selectedAgent.ID, t.ID, nil
+		return 0, t.ID, nil
 	}
-
-	// Order available agents by current task start time;
-	// ignore errors because we know all agents in availableAgentPool have tasks
-	_ = availableAgentPool.SortByTaskStartTime()
-	selectedAgent = availableAgentPool[0]
-
-	err = s.addTaskToAgentUnshift(selectedAgent.ID, t)
+	s.drainMu.Unlock()
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "s.addTaskToAgentUnshift()")
+		return 0, 0, err
+	}
+
+	// Tasks it displaced back to the pending queue may be taken by other agents
+	if returned > 0 {
+		s.assignPendingTasks()
 	}
-	return selectedAgent.ID, t.ID, nil
+
+	return assignedAgentID, t.ID, nil
 }
 
-// addTaskToAgentUnshift adds task to front of an agent's queue, effectively assigning it to them
-func (s *Store) addTaskToAgentUnshift(agentID uint, t *Task) error {
-	agent, err := s.FindAgent(agentID)
-	if err != nil {
-		return errors.Wrap(err, "s.FindAgent(agentID)")
+// assignTask selects an available agent for the task using the given strategy and adds it to their queue,
+// reporting how many of their tasks it displaced back to the pending queue under the preemption policy
+func (s *Store) assignTask(t *Task, strategy AssignmentStrategy, ci ChangeInfo) (assignedAgentID uint, returned int, err error) {
+	if ci.Reason == "" {
+		ci.Reason = fmt.Sprintf("Selected by %s strategy", strategy.Name())
 	}
 
+	// Select and assign under the one lock, so the agent cannot be taken or become
+	// unavailable in between. Every agent is run through the selection stages (see
+	// selectionStages); the strategy chooses among the best-matched candidates.
 	s.Lock()
-	defer s.Unlock()
+	selectedAgent, err := s.selectAgentExcluding(t, strategy, 0)
+	if err != nil {
+		s.Unlock()
+		return 0, 0, err
+	}
 
-	t.ID = s.NextTaskID()
-	t.AssignmentTime = time.Now()
-	t.State = TaskInWIP
-	agent.Tasks = append([]*Task{t}, agent.Tasks...)
-	return nil
+	// The task joins the agent's queue in priority order; one that outranks everything
+	// they hold goes first, displacing the rest under the preemption policy
+	op := OpPushTask
+	if len(selectedAgent.Tasks) > 0 && selectedAgent.heldTaskAtOrAbove(t.Priority) == nil {
+		op = OpUnshiftTask
+	}
+	err = s.addTaskToAgent(op, selectedAgent, t, ci)
+	s.Unlock()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "s.addTaskToAgent()")
+	}
+	if op == OpPushTask {
+		return selectedAgent.ID, 0, nil
+	}
+
+	// The task is assigned regardless; failing to move the tasks it displaced leaves them queued behind it
+	returned, err = s.preemptTasks(selectedAgent.ID, t, strategy)
+	if err != nil {
+		log.Errorf("Store.assignTask() --> s.preemptTasks(%d): %v", selectedAgent.ID, errors.Cause(err))
+	}
+	return selectedAgent.ID, returned, nil
 }
 
-// addTaskToAgentPush adds task to back of an agent's queue
-func (s *Store) addTaskToAgentPush(agentID uint, t *Task) error {
-	agent, err := s.FindAgent(agentID)
+// addTaskToAgent assigns a new or waiting task to the agent, at the end of their queue given by op
+// (OpPushTask or OpUnshiftTask); callers must hold the lock
+func (s *Store) addTaskToAgent(op JournalOp, agent *Agent, t *Task, ci ChangeInfo) error {
+	// A waiting task may have been assigned or withdrawn since it was selected
+	if t.ID != 0 && t.State.IsWaiting() && !s.isPendingTask(t.ID) {
+		return fmt.Errorf("Task is no longer pending")
+	}
+	err := t.State.checkTransition(TaskAssigned)
 	if err != nil {
-		return errors.Wrap(err, "s.FindAgent(agentID)")
+		return err
 	}
 
-	s.Lock()
-	defer s.Unlock()
+	events := []TaskEvent{}
+	if t.ID == 0 {
+		t.ID = s.NextTaskID()
+		events = append(events, newTaskEvent(TaskEventCreated, t, t.State, 0, ChangeInfo{Actor: ci.Actor}))
+	}
+	events = append(events, newTaskEvent(TaskEventAssigned, t, TaskAssigned, agent.ID, ci))
 
-	t.ID = s.NextTaskID()
 	t.AssignmentTime = time.Now()
-	t.State = TaskInWIP
-	agent.Tasks = append(agent.Tasks, t)
-	return nil
+	if t.FirstAssignedTime.IsZero() {
+		t.FirstAssignedTime = t.AssignmentTime
+	}
+	t.State = TaskAssigned
+	return s.commit(&JournalEntry{Op: op, AgentID: agent.ID, Task: t, Events: events})
 }
 
+// MarkAsCompleted moves a task from its agent's queue to the completed list
 func (s *Store) MarkAsCompleted(taskID uint) error {
-	task, err := s.FindTaskWithAgent(taskID)
+	return s.CompleteTask(taskID, ChangeInfo{})
+}
+
+// CompleteTask is MarkAsCompleted, attributing the change in the task's history
+func (s *Store) CompleteTask(taskID uint, ci ChangeInfo) error {
+	return s.CompleteTaskWithOptions(taskID, CompletionOptions{ChangeInfo: ci})
+}
+
+// CompletionOptions describes how a task was completed
+type CompletionOptions struct {
+	ChangeInfo
+
+	// Resolution is a free-text note on how the task was completed (optional)
+	Resolution string
+	// Outcome is a short code classifying how the task was completed (optional)
+	Outcome TaskOutcome
+}
+
+// CompleteTaskWithOptions is CompleteTask, also recording the task's resolution and outcome.
+// The completion time, and the handling time since assignment, are recorded on the task.
+func (s *Store) CompleteTaskWithOptions(taskID uint, opts CompletionOptions) error {
+	err := opts.Outcome.IsValid()
 	if err != nil {
-		return errors.Wrap(err, "s.FindTaskWithAgent(taskID)")
+		return err
 	}
 
 	s.Lock()
 
-	// Flag as complete
-	task.State = TaskComplete
+	task, err := s.findTaskWithAgent(taskID)
+	if err != nil {
+		s.Unlock()
+		return errors.Wrap(err, "s.findTaskWithAgent(taskID)")
+	}
+	err = task.State.checkTransition(TaskComplete)
+	if err != nil {
+		s.Unlock()
+		return err
+	}
+	event := newTaskEvent(TaskEventStateChanged, &task, TaskComplete, 0, opts.ChangeInfo)
 
-	// Add to completed list
-	s.completedTasks = append(s.completedTasks, &task)
+	// Flag as complete; applying the entry adds it to the completed list and
+	// purges it from the Agent's assignments in one step
+	now := time.Now()
+	task.State = TaskComplete
+	task.CompletedTime = now
+	if !task.AssignmentTime.IsZero() {
+		task.DurationSeconds = now.Sub(task.AssignmentTime).Seconds()
+	}
+	task.Resolution = opts.Resolution
+	task.Outcome = opts.Outcome
+	task.SLAStatus = s.slaPolicy.Evaluate(&task, now)
 
+	err = s.commit(&JournalEntry{Op: OpCompleteTask, Time: now, TaskID: taskID, Task: &task, Events: []TaskEvent{event}})
 	s.Unlock()
-
-	// Purge from Agent's assignments
-	err = s.DeleteTask(taskID)
 	if err != nil {
-		return errors.Wrap(err, "s.DeleteTask(taskID)")
+		return err
 	}
 
+	// The agent may now be free to take a waiting task
+	s.assignPendingTasks()
+
 	return nil
 }
 
+// ListCompletedTasks returns the tasks that have been marked as completed, oldest first
+func (s *Store) ListCompletedTasks() ([]*Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ts := make([]*Task, 0, len(s.completedTasks))
+	for _, t := range s.completedTasks {
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
+// ListCancelledTasks returns the tasks that have been cancelled, oldest first
+func (s *Store) ListCancelledTasks() ([]*Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ts := make([]*Task, 0, len(s.cancelledTasks))
+	for _, t := range s.cancelledTasks {
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
 // NextAgentID returns the next available ID that should be used for a new Agent{}
 func (s *Store) NextAgentID() uint {
-	id := uint(1)
+	id := s.retiredAgentID + 1
 	for _, agent := range s.agents {
 		if agent.ID >= id {
 			id = agent.ID + 1
@@ -230,7 +484,7 @@ func (s *Store) NextAgentID() uint {
 
 // NextTaskID returns the next available ID that should be used for a new Task{}
 func (s *Store) NextTaskID() uint {
-	id := uint(1)
+	id := s.retiredTaskID + 1
 	for _, agent := range s.agents {
 		for _, task := range agent.Tasks {
 			if task.ID >= id {
@@ -238,33 +492,44 @@ func (s *Store) NextTaskID() uint {
 			}
 		}
 	}
-	return id
-}
-
-// FindAgentsWithNecessarySkills returns a slice of agents with task required skills
-func (s *Store) FindAgentsWithNecessarySkills(ss Skills) (skilledAgents Agents, atLeastOneFound bool) {
-	s.RLock()
-	defer s.RUnlock()
-
-	skillMatchedAgents := Agents{}
-	for _, agent := range s.agents {
-		if !agent.HasSkills(ss) {
-			continue
+	// Pending, completed and cancelled task IDs are never reused
+	for _, task := range s.pendingTasks {
+		if task.ID >= id {
+			id = task.ID + 1
 		}
-		skillMatchedAgents = append(skillMatchedAgents, *agent)
 	}
-
-	return skillMatchedAgents, (len(skillMatchedAgents) > 0)
+	for _, task := range s.completedTasks {
+		if task.ID >= id {
+			id = task.ID + 1
+		}
+	}
+	for _, task := range s.cancelledTasks {
+		if task.ID >= id {
+			id = task.ID + 1
+		}
+	}
+	return id
 }
 
-// TESTING_resetTaskAssignmentTimes is for testing purposes; resets all Task.AssignmentTime values to time.Time{}
-func (s *Store) TESTING_resetTaskAssignmentTimes() {
+// TESTING_resetTimestamps is for testing purposes; resets all Task.AssignmentTime, Task.FirstAssignedTime,
+// Task.CreatedTime, Task.CompletedTime and Agent.IdleSince values to time.Time{} (and Task.DurationSeconds to 0)
+func (s *Store) TESTING_resetTimestamps() {
 	s.Lock()
 	defer s.Unlock()
 
 	for i := 0; i < len(s.agents); i++ {
+		s.agents[i].IdleSince = time.Time{}
 		for j := 0; j < len(s.agents[i].Tasks); j++ {
 			s.agents[i].Tasks[j].AssignmentTime = time.Time{}
+			s.agents[i].Tasks[j].FirstAssignedTime = time.Time{}
+			s.agents[i].Tasks[j].CreatedTime = time.Time{}
 		}
 	}
+	for _, t := range s.pendingTasks {
+		t.CreatedTime = time.Time{}
+	}
+	for _, t := range s.completedTasks {
+		t.CompletedTime = time.Time{}
+		t.DurationSeconds = 0
+	}
 }
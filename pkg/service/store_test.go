@@ -0,0 +1,82 @@
+package service
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// firstPickSource is a rand.Source that always yields 0, so that random agent
+// selection deterministically picks the first candidate
+type firstPickSource struct{}
+
+func (firstPickSource) Int63() int64 { return 0 }
+func (firstPickSource) Seed(int64)   {}
+
+// TestMain makes random agent selection pick the first candidate for every test,
+// restoring the previous source afterwards
+func TestMain(m *testing.M) {
+	previous := SetRandomSource(firstPickSource{})
+	code := m.Run()
+	SetRandomSource(previous)
+	os.Exit(code)
+}
+
+func Test_Store_AddTaskToAgent_Concurrent(t *testing.T) {
+	store := NewStore([]*Agent{
+		&Agent{Name: "Adam", Skills: Skills{Skill1}},
+		&Agent{Name: "Betty", Skills: Skills{Skill1}},
+		&Agent{Name: "Charlie", Skills: Skills{Skill1}, Capacity: Capacity{MaxTasks: 1}},
+		&Agent{Name: "Dana", Skills: Skills{Skill1}, Capacity: Capacity{MaxTasks: 1}},
+	}, nil)
+
+	// Submit a mix of priorities at once, across several threads even on a single CPU;
+	// every agent is free at first
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	const n = 40
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		priority := PriorityLow
+		if i%2 == 0 {
+			priority = PriorityHigh
+		}
+		wg.Add(1)
+		go func(p Priority) {
+			defer wg.Done()
+			<-start
+			_, _, err := store.AddTaskToAgent(&Task{Priority: p, ReqSkills: Skills{Skill1}})
+			errs <- err
+		}(priority)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// No agent went over capacity or took two tasks of equal rank, and no task was lost
+	ids := map[uint]bool{}
+	agents, _ := store.ListAgents()
+	for _, a := range agents {
+		if a.Capacity.MaxTasks > 0 {
+			assert.True(t, len(a.Tasks) <= a.Capacity.MaxTasks, "%s holds %d tasks", a.Name, len(a.Tasks))
+		}
+		ranks := map[int]bool{}
+		for _, task := range a.Tasks {
+			assert.False(t, ranks[task.Priority.Rank()], "%s holds two %v tasks", a.Name, task.Priority)
+			ranks[task.Priority.Rank()] = true
+			ids[task.ID] = true
+		}
+	}
+	pending, _ := store.ListPendingTasks()
+	for _, task := range pending {
+		ids[task.ID] = true
+	}
+	assert.Equal(t, n, len(ids))
+}
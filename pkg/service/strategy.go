@@ -0,0 +1,193 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// AssignmentStrategy selects which of the agents skilled and available for a
+// task it should be assigned to. Candidates are never empty.
+type AssignmentStrategy interface {
+	Name() string
+	SelectAgent(t *Task, candidates Agents) (Agent, error)
+}
+
+// AgentRanker is implemented by strategies that can order candidates as they would
+// choose between them, best first, without side effects (e.g. advancing a round-robin).
+// Candidates the strategy would choose between at random keep their existing order.
+type AgentRanker interface {
+	RankAgents(t *Task, candidates Agents) Agents
+}
+
+var (
+	// strategies are shared instances, so that stateful strategies (e.g. round-robin)
+	// keep their place whether selected per deployment or per request
+	strategies = map[string]AssignmentStrategy{}
+
+	defaultAssignmentStrategy = registerAssignmentStrategy(&StandardStrategy{})
+)
+
+func init() {
+	registerAssignmentStrategy(&RandomStrategy{})
+	registerAssignmentStrategy(&RoundRobinStrategy{})
+	registerAssignmentStrategy(&LeastLoadedStrategy{})
+	registerAssignmentStrategy(&LongestIdleStrategy{})
+}
+
+func registerAssignmentStrategy(st AssignmentStrategy) AssignmentStrategy {
+	strategies[st.Name()] = st
+	return st
+}
+
+// LookupAssignmentStrategy returns the strategy registered under the given name
+func LookupAssignmentStrategy(name string) (AssignmentStrategy, error) {
+	st, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown assignment strategy: %v", name)
+	}
+	return st, nil
+}
+
+// AssignmentStrategyNames returns the names of all registered strategies, sorted
+func AssignmentStrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StandardStrategy prefers idle agents, picking one at random; failing that,
+// it picks the agent whose current task was started most recently
+type StandardStrategy struct{}
+
+func (StandardStrategy) Name() string { return "standard" }
+
+func (StandardStrategy) SelectAgent(t *Task, candidates Agents) (Agent, error) {
+	// Prefer agents with no tasks assigned
+	idleAgents, ok := candidates.FilterForNoTasksAssigned()
+	if ok {
+		return idleAgents.PluckRandomAgent()
+	}
+
+	// Order available agents by current task start time;
+	// ignore errors because we know all candidates have tasks
+	_ = candidates.SortByTaskStartTime()
+	return candidates[0], nil
+}
+
+func (StandardStrategy) RankAgents(t *Task, candidates Agents) Agents {
+	idleAgents, _ := candidates.FilterForNoTasksAssigned()
+	return append(idleAgents, busyByTaskStartTime(candidates)...)
+}
+
+// RandomStrategy picks uniformly at random from all candidates, idle or not
+type RandomStrategy struct{}
+
+func (RandomStrategy) Name() string { return "random" }
+
+func (RandomStrategy) SelectAgent(t *Task, candidates Agents) (Agent, error) {
+	return candidates.PluckRandomAgent()
+}
+
+func (RandomStrategy) RankAgents(t *Task, candidates Agents) Agents {
+	return append(Agents{}, candidates...)
+}
+
+// RoundRobinStrategy cycles through agents in ID order, picking the first
+// candidate after the agent it last selected
+type RoundRobinStrategy struct {
+	mu          sync.Mutex
+	lastAgentID uint
+}
+
+func (*RoundRobinStrategy) Name() string { return "round_robin" }
+
+func (rr *RoundRobinStrategy) SelectAgent(t *Task, candidates Agents) (Agent, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	selected := candidates[0]
+	for _, a := range candidates {
+		if a.ID > rr.lastAgentID {
+			selected = a
+			break
+		}
+	}
+
+	rr.lastAgentID = selected.ID
+	return selected, nil
+}
+
+func (rr *RoundRobinStrategy) RankAgents(t *Task, candidates Agents) Agents {
+	rr.mu.Lock()
+	lastAgentID := rr.lastAgentID
+	rr.mu.Unlock()
+
+	// Agents after the last selected one come first, then the cycle wraps around
+	ranked := append(Agents{}, candidates...)
+	sort.Slice(ranked, func(i, j int) bool {
+		iNext, jNext := ranked[i].ID > lastAgentID, ranked[j].ID > lastAgentID
+		if iNext != jNext {
+			return iNext
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+	return ranked
+}
+
+// LeastLoadedStrategy picks the candidate with the fewest assigned tasks
+type LeastLoadedStrategy struct{}
+
+func (LeastLoadedStrategy) Name() string { return "least_loaded" }
+
+func (LeastLoadedStrategy) SelectAgent(t *Task, candidates Agents) (Agent, error) {
+	candidates.SortByTaskCount()
+	return candidates[0], nil
+}
+
+func (LeastLoadedStrategy) RankAgents(t *Task, candidates Agents) Agents {
+	ranked := append(Agents{}, candidates...)
+	ranked.SortByTaskCount()
+	return ranked
+}
+
+// LongestIdleStrategy picks the idle candidate whose queue has been empty the
+// longest; if no candidate is idle, it falls back to StandardStrategy
+type LongestIdleStrategy struct{}
+
+func (LongestIdleStrategy) Name() string { return "longest_idle" }
+
+func (LongestIdleStrategy) SelectAgent(t *Task, candidates Agents) (Agent, error) {
+	idleAgents, ok := candidates.FilterForNoTasksAssigned()
+	if !ok {
+		return StandardStrategy{}.SelectAgent(t, candidates)
+	}
+
+	idleAgents.SortByIdleSince()
+	return idleAgents[0], nil
+}
+
+func (LongestIdleStrategy) RankAgents(t *Task, candidates Agents) Agents {
+	idleAgents, _ := candidates.FilterForNoTasksAssigned()
+	idleAgents.SortByIdleSince()
+	return append(idleAgents, busyByTaskStartTime(candidates)...)
+}
+
+// busyByTaskStartTime returns the candidates holding tasks, by most-recently started task
+func busyByTaskStartTime(candidates Agents) Agents {
+	busyAgents := Agents{}
+	for _, a := range candidates {
+		if len(a.Tasks) > 0 {
+			busyAgents = append(busyAgents, a)
+		}
+	}
+	_ = busyAgents.SortByTaskStartTime()
+	return busyAgents
+}
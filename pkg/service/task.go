@@ -1,14 +1,52 @@
 package service
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 type Task struct {
-	ID             uint      `json:"id"`
-	Priority       Priority  `json:"priority"`
-	ReqSkills      Skills    `json:"required_skills"`
+	ID        uint     `json:"id"`
+	Priority  Priority `json:"priority"`
+	ReqSkills Skills   `json:"required_skills"`
+
+	// MinSkillLevels is the proficiency required in each of ReqSkills; MinSkillLevel if not given
+	MinSkillLevels SkillLevels `json:"min_skill_levels,omitempty"`
+	// PrefSkills never rule an agent out, but agents with more of them are preferred
+	PrefSkills Skills `json:"preferred_skills,omitempty"`
+	// EstimatedSeconds is how long the task is expected to take, if known
+	EstimatedSeconds int `json:"estimated_seconds,omitempty"`
+	// Deadline is when the task must be completed by, if it has one, on top of its priority's SLA targets
+	Deadline time.Time `json:"deadline"`
+	// SLAStatus is how the task stands against its SLA targets and deadline, as last checked
+	SLAStatus SLAStatus `json:"sla_status,omitempty"`
+
 	AssignedAgent  *Agent    `json:"assigned_agent,omitempty"`
 	AssignmentTime time.Time `json:"assignment_time"`
+	CreatedTime    time.Time `json:"created_time"`
 	State          TaskState `json:"task_state"`
+
+	// FirstAssignedTime is when the task was first assigned to an agent, which its time to
+	// assign is measured against; unlike AssignmentTime, reassignment never moves it
+	FirstAssignedTime time.Time `json:"first_assigned_time"`
+	// ReopenedTime is when the task was last reopened, if ever; its SLA targets are measured
+	// from then rather than from CreatedTime
+	ReopenedTime time.Time `json:"reopened_time"`
+
+	// CompletedTime is when the task was marked as completed; zero unless it is completed
+	CompletedTime time.Time `json:"completed_time"`
+	// DurationSeconds is the handling time, from assignment to completion
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	// Resolution is a free-text note on how the task was completed, if given
+	Resolution string `json:"resolution,omitempty"`
+	// Outcome is a short code classifying how the task was completed, if given
+	Outcome TaskOutcome `json:"outcome,omitempty"`
+
+	// CancelReason is why the task was withdrawn; set only while it is cancelled
+	CancelReason string `json:"cancel_reason,omitempty"`
 }
 
 func (t *Task) IsValid() error {
@@ -18,16 +56,60 @@ func (t *Task) IsValid() error {
 	if err := t.ReqSkills.IsValid(); err != nil {
 		return err
 	}
+	if err := t.MinSkillLevels.IsValid(t.ReqSkills); err != nil {
+		return err
+	}
+	for _, skill := range t.PrefSkills {
+		if err := skill.IsValid(); err != nil {
+			return err
+		}
+	}
+	if t.EstimatedSeconds < 0 {
+		return fmt.Errorf("Invalid estimated_seconds: %d", t.EstimatedSeconds)
+	}
 	return nil
 }
 
+// EstimatedDuration is EstimatedSeconds as a duration; zero if unknown
+func (t *Task) EstimatedDuration() time.Duration {
+	return time.Duration(t.EstimatedSeconds) * time.Second
+}
+
 func (t *Task) Clone() Task {
 	return Task{
-		ID:             t.ID,
-		Priority:       t.Priority,
-		ReqSkills:      t.ReqSkills,
-		AssignedAgent:  t.AssignedAgent,
-		AssignmentTime: t.AssignmentTime,
-		State:          t.State,
+		ID:                t.ID,
+		Priority:          t.Priority,
+		ReqSkills:         t.ReqSkills,
+		MinSkillLevels:    t.MinSkillLevels,
+		PrefSkills:        t.PrefSkills,
+		EstimatedSeconds:  t.EstimatedSeconds,
+		Deadline:          t.Deadline,
+		SLAStatus:         t.SLAStatus,
+		AssignedAgent:     t.AssignedAgent,
+		AssignmentTime:    t.AssignmentTime,
+		CreatedTime:       t.CreatedTime,
+		State:             t.State,
+		FirstAssignedTime: t.FirstAssignedTime,
+		ReopenedTime:      t.ReopenedTime,
+		CompletedTime:     t.CompletedTime,
+		DurationSeconds:   t.DurationSeconds,
+		Resolution:        t.Resolution,
+		Outcome:           t.Outcome,
+		CancelReason:      t.CancelReason,
 	}
 }
+
+// TaskOutcome is a short code classifying how a task was completed, e.g. "resolved" or "workaround"
+type TaskOutcome string
+
+// maxOutcomeLength bounds outcome codes, which are meant for grouping rather than prose
+const maxOutcomeLength = 64
+
+var ErrInvalidOutcome = fmt.Errorf("Invalid outcome")
+
+func (o TaskOutcome) IsValid() error {
+	if len(o) > maxOutcomeLength || strings.ContainsAny(string(o), " \t\r\n") {
+		return errors.Wrapf(ErrInvalidOutcome, "%q (a code of up to %d characters, without spaces)", o, maxOutcomeLength)
+	}
+	return nil
+}
@@ -1,8 +1,94 @@
 package service
 
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// TaskState is a task's position in its lifecycle; the numeric values are
+// stable, as they appear in the API's task_state field
 type TaskState int
 
 const (
-	TaskInWIP    TaskState = iota // 0
-	TaskComplete                  // 1
+	TaskInWIP     TaskState = iota // 0 - in_progress: the agent is working on it
+	TaskComplete                   // 1 - completed
+	TaskQueued                     // 2 - queued: waiting for an available agent
+	TaskAssigned                   // 3 - assigned: in an agent's queue, not yet started
+	TaskPaused                     // 4 - paused: the agent has set it aside
+	TaskCancelled                  // 5 - cancelled
+	TaskReopened                   // 6 - reopened: completed or cancelled, then revived; waiting for an agent
 )
+
+var ErrIllegalTransition = fmt.Errorf("Illegal task state transition")
+
+var taskStateNames = map[TaskState]string{
+	TaskInWIP:     "in_progress",
+	TaskComplete:  "completed",
+	TaskQueued:    "queued",
+	TaskAssigned:  "assigned",
+	TaskPaused:    "paused",
+	TaskCancelled: "cancelled",
+	TaskReopened:  "reopened",
+}
+
+// taskTransitions lists, for each state, the states a task may move to next
+var taskTransitions = map[TaskState][]TaskState{
+	// Assignment, or withdrawal before anyone picks it up
+	TaskQueued:   {TaskAssigned, TaskCancelled},
+	TaskReopened: {TaskAssigned, TaskCancelled},
+
+	// Held by an agent: work on it, hand it to another agent, or return it to the pool
+	TaskAssigned: {TaskInWIP, TaskComplete, TaskCancelled, TaskAssigned, TaskQueued},
+	TaskInWIP:    {TaskPaused, TaskComplete, TaskCancelled, TaskAssigned, TaskQueued},
+	TaskPaused:   {TaskInWIP, TaskCancelled, TaskAssigned, TaskQueued},
+
+	// Finished, unless revived
+	TaskComplete:  {TaskReopened},
+	TaskCancelled: {TaskReopened},
+}
+
+func (ts TaskState) String() string {
+	if name, ok := taskStateNames[ts]; ok {
+		return name
+	}
+	return fmt.Sprintf("TaskState(%d)", int(ts))
+}
+
+// ParseTaskState returns the state with the given name, e.g. "in_progress"
+func ParseTaskState(name string) (TaskState, error) {
+	for ts, n := range taskStateNames {
+		if n == name {
+			return ts, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid Task State: %v", name)
+}
+
+// CanTransitionTo reports whether a task may move from the receiver state to next
+func (ts TaskState) CanTransitionTo(next TaskState) bool {
+	for _, s := range taskTransitions[ts] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition returns ErrIllegalTransition (wrapped with both states) unless the move is allowed
+func (ts TaskState) checkTransition(next TaskState) error {
+	if !ts.CanTransitionTo(next) {
+		return errors.Wrapf(ErrIllegalTransition, "Task cannot move from %v to %v", ts, next)
+	}
+	return nil
+}
+
+// IsHeld reports whether a task in this state sits in an agent's queue
+func (ts TaskState) IsHeld() bool {
+	return ts == TaskAssigned || ts == TaskInWIP || ts == TaskPaused
+}
+
+// IsWaiting reports whether a task in this state sits in the pending queue
+func (ts TaskState) IsWaiting() bool {
+	return ts == TaskQueued || ts == TaskReopened
+}
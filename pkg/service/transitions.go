@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StartTask moves an assigned task to in_progress
+func (s *Store) StartTask(taskID uint, ci ChangeInfo) error {
+	return s.setHeldTaskState(taskID, TaskAssigned, TaskInWIP, ci)
+}
+
+// PauseTask sets an in-progress task aside; it stays in the agent's queue
+func (s *Store) PauseTask(taskID uint, ci ChangeInfo) error {
+	return s.setHeldTaskState(taskID, TaskInWIP, TaskPaused, ci)
+}
+
+// ResumeTask moves a paused task back to in_progress
+func (s *Store) ResumeTask(taskID uint, ci ChangeInfo) error {
+	return s.setHeldTaskState(taskID, TaskPaused, TaskInWIP, ci)
+}
+
+// setHeldTaskState changes the state of a task in an agent's queue, provided it is currently in state from
+func (s *Store) setHeldTaskState(taskID uint, from, to TaskState, ci ChangeInfo) error {
+	s.Lock()
+	defer s.Unlock()
+
+	agent, t := s.heldTaskByID(taskID)
+	if t == nil {
+		return ErrTaskNotFound
+	}
+	if t.State != from {
+		return errors.Wrapf(ErrIllegalTransition, "Task is %v, not %v", t.State, from)
+	}
+	err := t.State.checkTransition(to)
+	if err != nil {
+		return err
+	}
+
+	event := newTaskEvent(TaskEventStateChanged, t, to, agent.ID, ci)
+
+	return s.commit(&JournalEntry{Op: OpSetTaskState, TaskID: taskID, Task: &Task{ID: taskID, State: to}, Events: []TaskEvent{event}})
+}
+
+var ErrCancelReasonRequired = fmt.Errorf("A reason is required to cancel a task")
+
+// CancelTask withdraws a task, whether held by an agent or still waiting for one.
+// It is kept with the reason given, apart from completed tasks.
+func (s *Store) CancelTask(taskID uint, ci ChangeInfo) error {
+	if strings.TrimSpace(ci.Reason) == "" {
+		return ErrCancelReasonRequired
+	}
+
+	s.Lock()
+
+	var cancelled Task
+	var agentID uint
+	if held, err := s.findTaskWithAgent(taskID); err == nil {
+		cancelled = held
+		agentID = held.AssignedAgent.ID
+	} else if waiting := s.pendingTaskByID(taskID); waiting != nil {
+		cancelled = waiting.Clone()
+	} else {
+		s.Unlock()
+		return ErrTaskNotFound
+	}
+	err := cancelled.State.checkTransition(TaskCancelled)
+	if err != nil {
+		s.Unlock()
+		return err
+	}
+	event := newTaskEvent(TaskEventStateChanged, &cancelled, TaskCancelled, agentID, ci)
+
+	cancelled.State = TaskCancelled
+	cancelled.CancelReason = ci.Reason
+	err = s.commit(&JournalEntry{Op: OpCancelTask, TaskID: taskID, Task: &cancelled, Events: []TaskEvent{event}})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The agent may now be free to take a waiting task
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// ReopenTask revives a completed or cancelled task, returning it to the pending
+// queue (ahead of anything that arrived after it) to be assigned afresh
+func (s *Store) ReopenTask(taskID uint, ci ChangeInfo) error {
+	s.Lock()
+
+	found := s.finishedTaskByID(taskID)
+	if found == nil {
+		s.Unlock()
+		return ErrTaskNotFound
+	}
+	err := found.State.checkTransition(TaskReopened)
+	if err != nil {
+		s.Unlock()
+		return err
+	}
+
+	event := newTaskEvent(TaskEventReopened, found, TaskReopened, 0, ci)
+
+	// The task is held to its SLA targets afresh, from now
+	now := time.Now()
+	reopened := found.Clone()
+	reopened.AssignedAgent = nil
+	reopened.AssignmentTime = time.Time{}
+	reopened.FirstAssignedTime = time.Time{}
+	reopened.ReopenedTime = now
+	reopened.State = TaskReopened
+	reopened.CompletedTime = time.Time{}
+	reopened.DurationSeconds = 0
+	reopened.Resolution = ""
+	reopened.Outcome = ""
+	reopened.CancelReason = ""
+	reopened.SLAStatus = s.slaPolicy.Evaluate(&reopened, now)
+	err = s.commit(&JournalEntry{Op: OpReopenTask, Time: now, TaskID: taskID, Task: &reopened, Events: []TaskEvent{event}})
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.assignPendingTasks()
+
+	return nil
+}
+
+// heldTaskByID returns the stored task with the given ID from an agent's queue, along with that agent; callers must hold the lock
+func (s *Store) heldTaskByID(taskID uint) (*Agent, *Task) {
+	for _, a := range s.agents {
+		for _, t := range a.Tasks {
+			if t.ID == taskID {
+				return a, t
+			}
+		}
+	}
+	return nil, nil
+}
+
+// finishedTaskByID returns the stored completed or cancelled task with the given ID, or nil; callers must hold the lock
+func (s *Store) finishedTaskByID(taskID uint) *Task {
+	for _, ts := range [][]*Task{s.completedTasks, s.cancelledTasks} {
+		for _, t := range ts {
+			if t.ID == taskID {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// removeCompletedTask snips a task from the completed list; callers must hold the lock
+func (s *Store) removeCompletedTask(taskID uint) bool {
+	for i, t := range s.completedTasks {
+		if t.ID == taskID {
+			s.completedTasks = append(s.completedTasks[:i], s.completedTasks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// removeCancelledTask snips a task from the cancelled list; callers must hold the lock
+func (s *Store) removeCancelledTask(taskID uint) bool {
+	for i, t := range s.cancelledTasks {
+		if t.ID == taskID {
+			s.cancelledTasks = append(s.cancelledTasks[:i], s.cancelledTasks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}